@@ -0,0 +1,29 @@
+// Parse contract ABIs in JSON format, as plain ABI or inside compiler artifacts
+package abijson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// Parse parses an ABI in JSON format. Both a plain ABI (JSON array) and a compiler artifact with an "abi" field
+// (Hardhat, Truffle, Foundry) are accepted.
+func Parse(data []byte) (abi.ABI, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var artifact struct {
+			Abi json.RawMessage `json:"abi"`
+		}
+		if err := json.Unmarshal(data, &artifact); err != nil {
+			return abi.ABI{}, err
+		}
+		if len(artifact.Abi) == 0 {
+			return abi.ABI{}, errors.New("no abi field in JSON object")
+		}
+		data = artifact.Abi
+	}
+	return abi.JSON(bytes.NewReader(data))
+}
@@ -0,0 +1,29 @@
+package abijson_test
+
+import (
+	"testing"
+
+	"github.com/metachris/go-ethutils/abijson"
+)
+
+const transferABI = `[{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}],"outputs":[{"type":"bool"}]}]`
+
+func TestParse(t *testing.T) {
+	for name, data := range map[string]string{
+		"plain":    transferABI,
+		"artifact": `  {"contractName": "Token", "abi": ` + transferABI + `, "bytecode": "0x"}`,
+	} {
+		contractAbi, err := abijson.Parse([]byte(data))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if method, found := contractAbi.Methods["transfer"]; !found || method.Sig != "transfer(address,uint256)" {
+			t.Errorf("%s: transfer method not found", name)
+		}
+	}
+
+	if _, err := abijson.Parse([]byte(`{"contractName": "Token"}`)); err == nil {
+		t.Error("expected an error for an artifact without ABI")
+	}
+}
@@ -11,16 +11,38 @@ const (
 	// After detection
 	AddressTypeErc20         AddressType = "Erc20"
 	AddressTypeErc721        AddressType = "Erc721"
+	AddressTypeErc1155       AddressType = "Erc1155"
 	AddressTypeOtherContract AddressType = "OtherContract"
 	AddressTypeEOA           AddressType = "EOA" // couldn't detect a smart contract, classify as Externally Owned Address (EOA)
 )
 
+// ProxyType is the kind of proxy contract, see smartcontracts.DetectProxy
+type ProxyType string
+
+const (
+	ProxyTypeNone               ProxyType = ""
+	ProxyTypeEip1967            ProxyType = "Eip1967"            // implementation in the EIP-1967 slot (transparent and UUPS proxies)
+	ProxyTypeEip1967Beacon      ProxyType = "Eip1967Beacon"      // beacon in the EIP-1967 slot, which returns the implementation
+	ProxyTypeEip1822            ProxyType = "Eip1822"            // implementation in the EIP-1822 (UUPS draft) PROXIABLE slot
+	ProxyTypeOpenZeppelinLegacy ProxyType = "OpenZeppelinLegacy" // implementation in the ZeppelinOS slot
+	ProxyTypeEip1167            ProxyType = "Eip1167"            // minimal proxy (clone), implementation in the bytecode
+	ProxyTypeGnosisSafe         ProxyType = "GnosisSafe"         // Gnosis Safe proxy, implementation (master copy) in slot 0
+)
+
 type AddressDetail struct {
 	Address  string      `json:"address"`
 	Type     AddressType `json:"type"`
 	Name     string      `json:"name"`
 	Symbol   string      `json:"symbol"`
 	Decimals uint8       `json:"decimals"`
+
+	// MetadataURI is the ERC1155 metadata URI template, with "{id}" as placeholder for the hex token ID
+	MetadataURI string `json:"uri,omitempty"`
+
+	// ProxyType and Implementation are set for proxy contracts. The other fields describe the proxy as it answers
+	// calls, ie. with the code of the implementation.
+	ProxyType      ProxyType `json:"proxyType,omitempty"`
+	Implementation string    `json:"implementation,omitempty"`
 }
 
 // Returns a new unknown address detail
@@ -44,3 +66,11 @@ func (a *AddressDetail) IsErc20() bool {
 func (a *AddressDetail) IsErc721() bool {
 	return a.Type == AddressTypeErc721
 }
+
+func (a *AddressDetail) IsErc1155() bool {
+	return a.Type == AddressTypeErc1155
+}
+
+func (a *AddressDetail) IsProxy() bool {
+	return a.ProxyType != ProxyTypeNone
+}
@@ -3,22 +3,28 @@ package addresslookup
 import (
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/ethrpc"
 	"github.com/metachris/go-ethutils/smartcontracts"
 	"github.com/metachris/go-ethutils/utils"
 )
 
 type AddressLookupService struct {
-	Client *ethclient.Client
+	Client ethrpc.ContractReader // eg. *ethclient.Client, or nil to only use the cache
 
 	// Initialize address cache with data from JSON
 	Cache map[string]addressdetail.AddressDetail
 }
 
-func NewAddressLookupService(client *ethclient.Client) *AddressLookupService {
+func NewAddressLookupService(client ethrpc.ContractReader) *AddressLookupService {
+	// A nil *ethclient.Client would be a non-nil interface value
+	if v := reflect.ValueOf(client); v.Kind() == reflect.Ptr && v.IsNil() {
+		client = nil
+	}
+
 	return &AddressLookupService{
 		Client: client,
 		Cache:  make(map[string]addressdetail.AddressDetail),
@@ -30,12 +36,7 @@ func (ads *AddressLookupService) EnsureIsLoaded(a *addressdetail.AddressDetail)
 		return
 	}
 
-	b, _ := ads.GetAddressDetail(a.Address)
-	a.Address = b.Address
-	a.Type = b.Type
-	a.Name = b.Name
-	a.Symbol = b.Symbol
-	a.Decimals = b.Decimals
+	*a, _ = ads.GetAddressDetail(a.Address)
 }
 
 // GetAddressDetail returns the addressdetail.AddressDetail from JSON. If not exists then query the Blockchain and caches it for future use
@@ -2,12 +2,29 @@ package addresslookup_test
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/metachris/go-ethutils/addresslookup"
 )
 
+// serveJsonFiles serves the json directory (the source of the published files) and points the JsonUrl* variables to it
+func serveJsonFiles(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("json")))
+	t.Cleanup(server.Close)
+
+	urls := []*string{&addresslookup.JsonUrlAddresses, &addresslookup.JsonUrlEthplorerExchangeAddresses, &addresslookup.JsonUrlEtherscanTopminers}
+	for _, url := range urls {
+		original := *url
+		*url = server.URL + original[strings.LastIndex(original, "/"):]
+		t.Cleanup(func() { *url = original })
+	}
+}
+
 func TestAddressLookup(t *testing.T) {
+	serveJsonFiles(t)
 	s := addresslookup.NewAddressLookupService(nil)
 
 	addr, found := s.GetAddressDetail("0x3ecef08d0e2dad803847e052249bb4f8bff2d5bb") // MiningPoolHub
@@ -16,7 +33,7 @@ func TestAddressLookup(t *testing.T) {
 		t.Error("first address shouldn't be found, but was", addr)
 	}
 
-	// Add all addresses from web
+	// Add all addresses from the JSON files
 	err := s.AddAllAddresses()
 	if err != nil {
 		t.Error("couldn't add all addresses", err)
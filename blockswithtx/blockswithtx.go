@@ -4,36 +4,153 @@ package blockswithtx
 import (
 	"context"
 	"errors"
-	"log"
 	"math/big"
 	"sync"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/go-ethutils/ethrpc"
+	"github.com/metachris/go-ethutils/utils"
 )
 
 // BlockWithTxReceipts contains a single block and receipts for all its transactions (eth does not guarantee that every tx has a receipt)
 type BlockWithTxReceipts struct {
 	Block      *types.Block
 	TxReceipts map[common.Hash]*types.Receipt
+
+	// TxTraces has the call tree of every transaction, if Options.Traces is set
+	TxTraces map[common.Hash]*CallFrame
+
+	// Attempts is the highest number of attempts any single RPC call for this block needed (1 = nothing was retried)
+	Attempts int
+}
+
+// Options configures GetBlockWithTxReceiptsWithOptions, GetBlocksWithTxReceiptsWithOptions and the follow/stream functions
+type Options struct {
+	utils.PipelineOptions
+
+	// MaxReorgDepth is the number of recent blocks StreamBlocksWithTxReceipts remembers to find the common ancestor
+	// on a chain reorganization (defaults to DefaultMaxReorgDepth)
+	MaxReorgDepth int
+
+	// Traces also fetches the call traces of all transactions (internal calls and ETH transfers) into
+	// BlockWithTxReceipts.TxTraces, using TraceMethod (default: debug_traceBlockByNumber with the callTracer, or
+	// trace_block if the node doesn't support it). Needs a raw JSON-RPC connection, and a node which keeps the
+	// historical state (archive node) for older blocks.
+	Traces      bool
+	TraceMethod TraceMethod
+
+	// MethodSupport remembers which methods the node supports (eth_getBlockReceipts, and the trace method with
+	// TraceMethodAuto), so that they are only detected once. The range and follow/stream functions use a new one for
+	// each run if it is not set. Share one across calls of GetBlockWithTxReceiptsWithOptions, but only for the same node.
+	MethodSupport *MethodSupport
+}
+
+// MethodSupport remembers which JSON-RPC methods a node supports. The zero value knows nothing yet. Safe for concurrent
+// use.
+type MethodSupport struct {
+	lock               sync.Mutex
+	blockReceiptsKnown bool
+	blockReceipts      bool
+	traceMethod        TraceMethod // TraceMethodAuto if not known yet
+}
+
+func (s *MethodSupport) getBlockReceipts() (supported bool, known bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.blockReceipts, s.blockReceiptsKnown
+}
+
+func (s *MethodSupport) setBlockReceipts(supported bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.blockReceipts, s.blockReceiptsKnown = supported, true
+}
+
+func (s *MethodSupport) getTraceMethod() TraceMethod {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.traceMethod
+}
+
+func (s *MethodSupport) setTraceMethod(method TraceMethod) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.traceMethod = method
+}
+
+// withMethodSupport returns the options with a MethodSupport, for detecting the supported methods only once
+func (opts Options) withMethodSupport() Options {
+	if opts.MethodSupport == nil {
+		opts.MethodSupport = &MethodSupport{}
+	}
+	return opts
 }
 
 // GetBlockWithTxReceipts returns a single block with receipts for all transactions
-func GetBlockWithTxReceipts(client *ethclient.Client, height int64) (res *BlockWithTxReceipts, err error) {
+func GetBlockWithTxReceipts(client ethrpc.BlockReceiptReader, height int64) (res *BlockWithTxReceipts, err error) {
+	return GetBlockWithTxReceiptsContext(context.Background(), client, height)
+}
+
+// GetBlockWithTxReceiptsContext is like GetBlockWithTxReceipts, but all RPC calls are bound to ctx (use it for deadlines and cancellation)
+func GetBlockWithTxReceiptsContext(ctx context.Context, client ethrpc.BlockReceiptReader, height int64) (res *BlockWithTxReceipts, err error) {
+	return GetBlockWithTxReceiptsWithOptions(ctx, client, height, Options{})
+}
+
+// GetBlockWithTxReceiptsWithOptions is like GetBlockWithTxReceiptsContext, with additional options (eg. a retry policy
+// for the BlockByNumber and TransactionReceipt calls). If there's a raw JSON-RPC connection (opts.RPC, or a client like
+// *ethrpc.Client), the receipts are fetched with a single eth_getBlockReceipts call if the node supports it (detected
+// once per opts.MethodSupport), else with batch requests.
+func GetBlockWithTxReceiptsWithOptions(ctx context.Context, client ethrpc.BlockReceiptReader, height int64, opts Options) (res *BlockWithTxReceipts, err error) {
+	opts = opts.withMethodSupport()
 	res = &BlockWithTxReceipts{}
 	res.TxReceipts = make(map[common.Hash]*types.Receipt)
 
 	// Get the block
-	res.Block, err = client.BlockByNumber(context.Background(), big.NewInt(height))
+	res.Attempts, err = opts.Retry.Do(ctx, func(ctx context.Context) (err error) {
+		res.Block, err = client.BlockByNumber(ctx, big.NewInt(height))
+		return err
+	})
 	if err != nil {
 		return res, err
 	}
 
-	// Get receipts for all transactions
+	rpcCaller := opts.GetRPCCaller(client)
+	if opts.Traces {
+		if rpcCaller == nil {
+			return res, errors.New("fetching traces needs a raw RPC connection (PipelineOptions.RPC)")
+		}
+
+		var attempts int
+		res.TxTraces, attempts, err = getBlockTraces(ctx, rpcCaller, res.Block, opts.TraceMethod, opts.MethodSupport, opts.Retry)
+		if attempts > res.Attempts {
+			res.Attempts = attempts
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+
+	// Get receipts for all transactions, with as few calls as possible if there's a raw RPC connection
+	if rpcCaller != nil {
+		var attempts int
+		res.TxReceipts, attempts, err = getBlockReceipts(ctx, rpcCaller, res.Block, opts.MethodSupport, opts.Retry)
+		if attempts > res.Attempts {
+			res.Attempts = attempts
+		}
+		return res, err
+	}
+
 	for _, tx := range res.Block.Transactions() {
-		receipt, err := client.TransactionReceipt(context.Background(), tx.Hash())
+		var receipt *types.Receipt
+		attempts, err := opts.Retry.Do(ctx, func(ctx context.Context) (err error) {
+			receipt, err = client.TransactionReceipt(ctx, tx.Hash())
+			return err
+		})
+		if attempts > res.Attempts {
+			res.Attempts = attempts
+		}
 		if err != nil {
 			if errors.Is(err, ethereum.NotFound) {
 				// can apparently happen if 0 tx: https://etherscan.io/block/10102170
@@ -50,33 +167,47 @@ func GetBlockWithTxReceipts(client *ethclient.Client, height int64) (res *BlockW
 
 // GetBlocksWithTxReceipts downloads a range of blocks with tx receipts, and sends each to a channel once it is ready.
 // Uses concurrency parallel connections to get data from the eth node fast. 5 seems a good number for a direct IPC connection.
-func GetBlocksWithTxReceipts(client *ethclient.Client, blockChan chan<- *BlockWithTxReceipts, startBlock int64, endBlock int64, concurrency int) {
-	var blockWorkerWg sync.WaitGroup
-	blockHeightChan := make(chan int64, 100) // blockHeight to fetch with receipts
-
-	// Start eth client thread pool
-	for w := 1; w <= concurrency; w++ {
-		blockWorkerWg.Add(1)
-
-		go func() {
-			defer blockWorkerWg.Done()
-			for blockHeight := range blockHeightChan {
-				res, err := GetBlockWithTxReceipts(client, blockHeight)
-				if err != nil {
-					log.Println("Error getting block with tx receipts:", err)
-					continue
-				}
-				blockChan <- res
-			}
-		}()
-	}
+// Blocks that could not be downloaded are skipped, and reported in the returned *utils.BlockRangeError.
+func GetBlocksWithTxReceipts(client ethrpc.BlockReceiptReader, blockChan chan<- *BlockWithTxReceipts, startBlock int64, endBlock int64, concurrency int) error {
+	return GetBlocksWithTxReceiptsContext(context.Background(), client, blockChan, startBlock, endBlock, concurrency)
+}
 
-	// Push blocks into channel, for workers to pick up
-	for currentBlockNumber := startBlock; currentBlockNumber <= endBlock; currentBlockNumber++ {
-		blockHeightChan <- currentBlockNumber
-	}
+// GetBlocksWithTxReceiptsContext is like GetBlocksWithTxReceipts, but can be cancelled through ctx. On cancellation no more
+// heights are handed out, in-flight RPC calls are aborted, and ctx.Err() is returned once all workers have stopped.
+// A nil error means the whole range was processed.
+func GetBlocksWithTxReceiptsContext(ctx context.Context, client ethrpc.BlockReceiptReader, blockChan chan<- *BlockWithTxReceipts, startBlock int64, endBlock int64, concurrency int) error {
+	return GetBlocksWithTxReceiptsWithOptions(ctx, client, blockChan, startBlock, endBlock, Options{PipelineOptions: utils.PipelineOptions{Concurrency: concurrency}})
+}
 
-	// Close worker channel and wait for workers to finish
-	close(blockHeightChan)
-	blockWorkerWg.Wait()
+// GetBlocksWithTxReceiptsWithOptions is like GetBlocksWithTxReceiptsContext, with additional options (eg. a retry policy)
+func GetBlocksWithTxReceiptsWithOptions(ctx context.Context, client ethrpc.BlockReceiptReader, blockChan chan<- *BlockWithTxReceipts, startBlock int64, endBlock int64, opts Options) error {
+	return newPipeline(client, blockChan, opts).Run(ctx, startBlock, endBlock)
+}
+
+// FollowBlocksWithTxReceipts sends all blocks (with receipts) from startBlock up to the chain head to blockChan, and then
+// keeps streaming new blocks as they arrive (lagging opts.Confirmations blocks behind the head). It uses a newHeads
+// subscription on WebSocket/IPC connections and polls the head on HTTP. Runs until ctx is done.
+func FollowBlocksWithTxReceipts(ctx context.Context, client ethrpc.ChainReceiptReader, blockChan chan<- *BlockWithTxReceipts, startBlock int64, opts Options) error {
+	return newPipeline(client, blockChan, opts).Follow(ctx, client, startBlock)
+}
+
+func newPipeline(client ethrpc.BlockReceiptReader, blockChan chan<- *BlockWithTxReceipts, opts Options) *utils.BlockPipeline {
+	opts = opts.withMethodSupport()
+	return &utils.BlockPipeline{
+		Options: opts.PipelineOptions,
+		Fetch: func(ctx context.Context, height int64) (interface{}, error) {
+			return GetBlockWithTxReceiptsWithOptions(ctx, client, height, opts)
+		},
+		Emit: func(ctx context.Context, result interface{}) error {
+			select {
+			case blockChan <- result.(*BlockWithTxReceipts):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		Items: func(result interface{}) int {
+			return len(result.(*BlockWithTxReceipts).Block.Transactions())
+		},
+	}
 }
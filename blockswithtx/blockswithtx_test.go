@@ -0,0 +1,355 @@
+package blockswithtx_test
+
+import (
+	"context"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/metachris/go-ethutils/blockswithtx"
+	"github.com/metachris/go-ethutils/ethrpc"
+	"github.com/metachris/go-ethutils/rpctest"
+)
+
+// The chain fixtures have blocks 12323930 to 12323970, with height%4 transactions each
+const chainFixtures = "../rpctest/testdata/chain.json"
+
+func newChainServer(t *testing.T) *rpctest.Server {
+	fixtures, err := rpctest.LoadFixtures(chainFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func checkBlocks(t *testing.T, blockChan chan *blockswithtx.BlockWithTxReceipts, numBlocks int) {
+	t.Helper()
+	close(blockChan)
+
+	count := 0
+	for b := range blockChan {
+		count++
+		if len(b.Block.Transactions()) != int(b.Block.Number().Int64()%4) {
+			t.Errorf("block %d has %d transactions", b.Block.Number(), len(b.Block.Transactions()))
+		}
+		for _, tx := range b.Block.Transactions() {
+			receipt := b.TxReceipts[tx.Hash()]
+			if receipt == nil || receipt.BlockHash != b.Block.Hash() {
+				t.Errorf("missing or wrong receipt for tx %s in block %d", tx.Hash(), b.Block.Number())
+			}
+		}
+	}
+	if count != numBlocks {
+		t.Errorf("got %d blocks, expected %d", count, numBlocks)
+	}
+}
+
+func TestGetBlocksWithTxReceipts(t *testing.T) {
+	server := newChainServer(t)
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	blockChan := make(chan *blockswithtx.BlockWithTxReceipts, 100)
+	if err := blockswithtx.GetBlocksWithTxReceipts(client, blockChan, 12323930, 12323949, 5); err != nil {
+		t.Fatal(err)
+	}
+	checkBlocks(t, blockChan, 20)
+
+	if n := server.Calls("eth_getTransactionReceipt"); n != 30 {
+		t.Errorf("eth_getTransactionReceipt called %d times, expected 30", n)
+	}
+}
+
+func TestGetBlocksWithTxReceiptsBlockReceipts(t *testing.T) {
+	server := newChainServer(t)
+	client, err := ethrpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	blockChan := make(chan *blockswithtx.BlockWithTxReceipts, 100)
+	if err := blockswithtx.GetBlocksWithTxReceipts(client, blockChan, 12323930, 12323949, 5); err != nil {
+		t.Fatal(err)
+	}
+	checkBlocks(t, blockChan, 20)
+
+	if n := server.Calls("eth_getBlockReceipts"); n != 15 { // blocks without transactions don't need receipts
+		t.Errorf("eth_getBlockReceipts called %d times, expected 15", n)
+	}
+	if n := server.Calls("eth_getTransactionReceipt"); n != 0 {
+		t.Errorf("eth_getTransactionReceipt called %d times, expected 0", n)
+	}
+}
+
+func TestGetBlocksWithTxReceiptsBatchFallback(t *testing.T) {
+	server := newChainServer(t)
+	server.Fixtures.RemoveMethod("eth_getBlockReceipts")
+	client, err := ethrpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	blockChan := make(chan *blockswithtx.BlockWithTxReceipts, 100)
+	if err := blockswithtx.GetBlocksWithTxReceipts(client, blockChan, 12323930, 12323949, 1); err != nil {
+		t.Fatal(err)
+	}
+	checkBlocks(t, blockChan, 20)
+
+	if n := server.Calls("eth_getBlockReceipts"); n != 1 {
+		t.Errorf("eth_getBlockReceipts called %d times, expected 1 (support is detected once)", n)
+	}
+	if n := server.Calls("eth_getTransactionReceipt"); n != 30 {
+		t.Errorf("eth_getTransactionReceipt called %d times, expected 30", n)
+	}
+
+	// Single blocks detect it every time, unless they share a MethodSupport
+	shared := blockswithtx.Options{MethodSupport: &blockswithtx.MethodSupport{}}
+	for _, opts := range []blockswithtx.Options{{}, {}, shared, shared} {
+		if _, err := blockswithtx.GetBlockWithTxReceiptsWithOptions(context.Background(), client, 12323931, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := server.Calls("eth_getBlockReceipts"); n != 4 {
+		t.Errorf("eth_getBlockReceipts called %d times, expected 4", n)
+	}
+}
+
+// pipelineGoroutines returns the stacks of all goroutines in the pipeline code of utils and blockswithtx
+func pipelineGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	var stacks []string
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		if pipelineFrame.MatchString(stack) {
+			stacks = append(stacks, stack)
+		}
+	}
+	return stacks
+}
+
+var pipelineFrame = regexp.MustCompile(`go-ethutils/(utils|blockswithtx)\.`)
+
+func TestGetBlocksWithTxReceiptsCancel(t *testing.T) {
+	// Every call takes 20ms, unless it's aborted
+	server := newChainServer(t)
+	var requests int64
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		select {
+		case <-time.After(20 * time.Millisecond):
+			server.ServeHTTP(w, r)
+		case <-r.Context().Done():
+		}
+	}))
+	defer slowServer.Close()
+	client, err := ethrpc.Dial(slowServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blockChan := make(chan *blockswithtx.BlockWithTxReceipts)
+	getErr := make(chan error, 1)
+	go func() {
+		getErr <- blockswithtx.GetBlocksWithTxReceiptsContext(ctx, client, blockChan, 12323930, 12323970, 4)
+	}()
+
+	// Cancel after a few blocks, and stop consuming
+	for i := 0; i < 3; i++ {
+		<-blockChan
+	}
+	cancel()
+
+	select {
+	case err := <-getErr:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not returned after cancellation")
+	}
+
+	// No new requests once the ones sent before cancelling arrived, and nothing left running
+	time.Sleep(50 * time.Millisecond)
+	sent := atomic.LoadInt64(&requests)
+	if sent >= 41 {
+		t.Errorf("%d requests sent, expected to stop before the end of the range", sent)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&requests); n != sent {
+		t.Errorf("%d more requests sent after returning", n-sent)
+	}
+	if stacks := pipelineGoroutines(); len(stacks) > 0 {
+		t.Errorf("%d goroutines left running:\n%s", len(stacks), strings.Join(stacks, "\n\n"))
+	}
+}
+
+func TestStreamBlocksWithTxReceipts(t *testing.T) {
+	server := newChainServer(t)
+	client, err := ethrpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	opts := blockswithtx.Options{}
+	opts.Concurrency = 4
+	opts.PollInterval = 10 * time.Millisecond
+	eventChan, streamErr, cancel := streamUntilHead(t, client, 12323960, opts)
+	defer cancel()
+
+	// No more blocks at the head
+	select {
+	case event := <-eventChan:
+		t.Fatalf("unexpected event %s", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-streamErr; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+// forkChain replaces the blocks from height fromBlock on with a fork of empty blocks up to toBlock, which becomes the
+// new head
+func forkChain(t *testing.T, server *rpctest.Server, client ethrpc.ChainReader, fromBlock, toBlock int64) {
+	t.Helper()
+	parent, err := client.HeaderByNumber(context.Background(), big.NewInt(fromBlock-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parentHash := parent.Hash()
+	for height := fromBlock; height <= toBlock; height++ {
+		header := &types.Header{
+			ParentHash: parentHash,
+			Root:       crypto.Keccak256Hash([]byte("fork"), big.NewInt(height).Bytes()),
+			Difficulty: parent.Difficulty,
+			Number:     big.NewInt(height),
+			GasLimit:   parent.GasLimit,
+			Time:       parent.Time + uint64(height-parent.Number.Int64())*13,
+			BaseFee:    parent.BaseFee,
+		}
+		block := types.NewBlock(header, nil, nil, nil, trie.NewStackTrie(nil))
+		if err := server.Fixtures.AddBlock(block, nil, height == toBlock); err != nil {
+			t.Fatal(err)
+		}
+		parentHash = block.Hash()
+	}
+}
+
+// streamUntilHead streams blocks from startBlock and reads the events up to the head of the fixtures (12323970)
+func streamUntilHead(t *testing.T, client ethrpc.ChainReceiptReader, startBlock int64, opts blockswithtx.Options) (eventChan chan blockswithtx.BlockEvent, streamErr chan error, cancel context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	eventChan = make(chan blockswithtx.BlockEvent)
+	streamErr = make(chan error, 1)
+	go func() {
+		streamErr <- blockswithtx.StreamBlocksWithTxReceipts(ctx, client, eventChan, startBlock, opts)
+	}()
+
+	for height := startBlock; height <= 12323970; height++ {
+		event := <-eventChan
+		if event.Type != blockswithtx.BlockEventNew || event.Block.Block.Number().Int64() != height {
+			cancel()
+			t.Fatalf("unexpected event %s, expected new block %d", event, height)
+		}
+	}
+	return eventChan, streamErr, cancel
+}
+
+func TestStreamBlocksWithTxReceiptsReorg(t *testing.T) {
+	server := newChainServer(t)
+	client, err := ethrpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	opts := blockswithtx.Options{}
+	opts.Concurrency = 4
+	opts.PollInterval = 10 * time.Millisecond
+	eventChan, streamErr, cancel := streamUntilHead(t, client, 12323960, opts)
+	defer cancel()
+
+	// Blocks 12323966 to 12323970 are orphaned, the new chain has two more blocks
+	forkChain(t, server, client, 12323966, 12323972)
+
+	for height := int64(12323970); height >= 12323966; height-- {
+		event := <-eventChan
+		if event.Type != blockswithtx.BlockEventRevert || event.Block.Block.Number().Int64() != height {
+			t.Fatalf("unexpected event %s, expected revert of block %d", event, height)
+		}
+		if len(event.Block.Block.Transactions()) != int(height%4) {
+			t.Errorf("reverted block %d is not the orphaned one", height)
+		}
+	}
+	for height := int64(12323966); height <= 12323972; height++ {
+		event := <-eventChan
+		if event.Type != blockswithtx.BlockEventNew || event.Block.Block.Number().Int64() != height {
+			t.Fatalf("unexpected event %s, expected new block %d", event, height)
+		}
+		if len(event.Block.Block.Transactions()) != 0 {
+			t.Errorf("new block %d is not from the fork", height)
+		}
+	}
+
+	select {
+	case event := <-eventChan:
+		t.Fatalf("unexpected event %s", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-streamErr; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestStreamBlocksWithTxReceiptsReorgTooDeep(t *testing.T) {
+	server := newChainServer(t)
+	client, err := ethrpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	opts := blockswithtx.Options{MaxReorgDepth: 2}
+	opts.Concurrency = 4
+	opts.PollInterval = 10 * time.Millisecond
+	eventChan, streamErr, cancel := streamUntilHead(t, client, 12323960, opts)
+	defer cancel()
+
+	forkChain(t, server, client, 12323966, 12323972)
+
+	// Only the 2 remembered blocks can be reverted
+	for height := int64(12323970); height >= 12323969; height-- {
+		event := <-eventChan
+		if event.Type != blockswithtx.BlockEventRevert || event.Block.Block.Number().Int64() != height {
+			t.Fatalf("unexpected event %s, expected revert of block %d", event, height)
+		}
+	}
+	if err := <-streamErr; err != blockswithtx.ErrReorgTooDeep {
+		t.Errorf("expected ErrReorgTooDeep, got %v", err)
+	}
+}
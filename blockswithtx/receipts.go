@@ -0,0 +1,92 @@
+package blockswithtx
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/go-ethutils/ethrpc"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+// Maximum number of eth_getTransactionReceipt calls per batch request (providers limit the batch size)
+const receiptsBatchSize = 100
+
+// getBlockReceipts fetches the receipts of all transactions in the block with as few round-trips as possible: a single
+// eth_getBlockReceipts call if the node supports it, else batch requests of eth_getTransactionReceipt. Returns the
+// highest number of attempts any call needed.
+func getBlockReceipts(ctx context.Context, caller ethrpc.RPCCaller, block *types.Block, support *MethodSupport, retry utils.RetryPolicy) (receipts map[common.Hash]*types.Receipt, attempts int, err error) {
+	receipts = make(map[common.Hash]*types.Receipt)
+	if len(block.Transactions()) == 0 {
+		return receipts, 0, nil
+	}
+
+	if supported, known := support.getBlockReceipts(); !known || supported {
+		var blockReceipts []*types.Receipt
+		attempts, err = retry.Do(ctx, func(ctx context.Context) error {
+			return caller.CallContext(ctx, &blockReceipts, "eth_getBlockReceipts", block.Hash())
+		})
+
+		switch {
+		case err == nil:
+			support.setBlockReceipts(true)
+			if len(blockReceipts) != len(block.Transactions()) {
+				return receipts, attempts, fmt.Errorf("eth_getBlockReceipts returned %d receipts for %d transactions in block %s", len(blockReceipts), len(block.Transactions()), block.Hash())
+			}
+			for _, receipt := range blockReceipts {
+				receipts[receipt.TxHash] = receipt
+			}
+			return receipts, attempts, nil
+		case ethrpc.IsMethodNotSupported(err):
+			support.setBlockReceipts(false)
+		default:
+			return receipts, attempts, err
+		}
+	}
+
+	// Fallback: batches of eth_getTransactionReceipt
+	txs := block.Transactions()
+	for start := 0; start < len(txs); start += receiptsBatchSize {
+		end := start + receiptsBatchSize
+		if end > len(txs) {
+			end = len(txs)
+		}
+
+		batch := make([]rpc.BatchElem, end-start)
+		batchReceipts := make([]*types.Receipt, end-start)
+		batchAttempts, err := retry.Do(ctx, func(ctx context.Context) error {
+			for i, tx := range txs[start:end] {
+				batchReceipts[i] = nil
+				batch[i] = rpc.BatchElem{Method: "eth_getTransactionReceipt", Args: []interface{}{tx.Hash()}, Result: &batchReceipts[i]}
+			}
+
+			if err := caller.BatchCallContext(ctx, batch); err != nil {
+				return err
+			}
+			for _, elem := range batch {
+				if elem.Error != nil {
+					return elem.Error
+				}
+			}
+			return nil
+		})
+		if batchAttempts > attempts {
+			attempts = batchAttempts
+		}
+		if err != nil {
+			return receipts, attempts, err
+		}
+
+		for i, receipt := range batchReceipts {
+			if receipt == nil {
+				// no receipt for this tx, same as ethereum.NotFound with TransactionReceipt
+				continue
+			}
+			receipts[txs[start+i].Hash()] = receipt
+		}
+	}
+
+	return receipts, attempts, nil
+}
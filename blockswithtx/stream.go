@@ -0,0 +1,169 @@
+package blockswithtx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// DefaultMaxReorgDepth is the default number of recent blocks StreamBlocksWithTxReceipts keeps to handle reorgs
+const DefaultMaxReorgDepth = 64
+
+// ErrReorgTooDeep is returned by StreamBlocksWithTxReceipts if no common ancestor was found within the remembered blocks
+var ErrReorgTooDeep = errors.New("chain reorganization deeper than the number of remembered blocks")
+
+type BlockEventType string
+
+const (
+	BlockEventNew    BlockEventType = "new"    // Block is the next canonical block
+	BlockEventRevert BlockEventType = "revert" // Block was sent before, but is no longer part of the canonical chain
+)
+
+// BlockEvent is sent by StreamBlocksWithTxReceipts
+type BlockEvent struct {
+	Type  BlockEventType
+	Block *BlockWithTxReceipts
+}
+
+func (e BlockEvent) String() string {
+	return fmt.Sprintf("%s %d %s", e.Type, e.Block.Block.NumberU64(), e.Block.Block.Hash())
+}
+
+// StreamBlocksWithTxReceipts follows the chain like FollowBlocksWithTxReceipts, and additionally verifies that every
+// block builds on the previous one. On a chain reorganization it walks back to the common ancestor, sends a
+// BlockEventRevert for every orphaned block (newest first), and then the new canonical blocks as BlockEventNew.
+// Blocks are always sent in order. Runs until ctx is done, or until a reorg is deeper than opts.MaxReorgDepth.
+func StreamBlocksWithTxReceipts(ctx context.Context, client ethrpc.ChainReceiptReader, eventChan chan<- BlockEvent, startBlock int64, opts Options) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	opts.Ordered = true
+	opts = opts.withMethodSupport() // shared by the pipeline and the tracker
+	blockChan := make(chan *BlockWithTxReceipts, opts.Concurrency)
+	followErr := make(chan error, 1)
+	go func() {
+		followErr <- FollowBlocksWithTxReceipts(ctx, client, blockChan, startBlock, opts)
+		close(blockChan)
+	}()
+
+	s := &reorgTracker{client: client, eventChan: eventChan, opts: opts, nextHeight: startBlock}
+	for block := range blockChan {
+		if err := s.handleBlock(ctx, block); err != nil {
+			cancel()
+			<-followErr
+			return err
+		}
+	}
+	return <-followErr
+}
+
+// reorgTracker remembers the recently sent blocks, to check the parent hash of each new block against
+type reorgTracker struct {
+	client     ethrpc.ChainReceiptReader
+	eventChan  chan<- BlockEvent
+	opts       Options
+	nextHeight int64
+	recent     []*BlockWithTxReceipts // oldest first
+}
+
+func (s *reorgTracker) maxDepth() int {
+	if s.opts.MaxReorgDepth > 0 {
+		return s.opts.MaxReorgDepth
+	}
+	return DefaultMaxReorgDepth
+}
+
+func (s *reorgTracker) handleBlock(ctx context.Context, block *BlockWithTxReceipts) error {
+	// The pipeline skips heights it could not fetch, these have to be filled in first
+	for s.nextHeight < block.Block.Number().Int64() {
+		missing, err := GetBlockWithTxReceiptsWithOptions(ctx, s.client, s.nextHeight, s.opts)
+		if err != nil {
+			return err
+		}
+		if err := s.handleBlock(ctx, missing); err != nil {
+			return err
+		}
+	}
+
+	for {
+		if len(s.recent) == 0 || block.Block.ParentHash() == s.recent[len(s.recent)-1].Block.Hash() {
+			return s.add(ctx, block)
+		}
+
+		// Reorg: revert all blocks which are no longer canonical, then re-fetch the canonical ones up to and including
+		// this height (the block itself may be from the orphaned fork too)
+		ancestorHeight, err := s.revertToCommonAncestor(ctx)
+		if err != nil {
+			return err
+		}
+
+		height := block.Block.Number().Int64()
+		for h := ancestorHeight + 1; h < height; h++ {
+			canonical, err := GetBlockWithTxReceiptsWithOptions(ctx, s.client, h, s.opts)
+			if err != nil {
+				return err
+			}
+			if err := s.handleBlock(ctx, canonical); err != nil {
+				return err
+			}
+		}
+
+		block, err = GetBlockWithTxReceiptsWithOptions(ctx, s.client, height, s.opts)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// revertToCommonAncestor sends revert events for the remembered blocks which are no longer canonical, and returns the
+// height of the newest block that still is
+func (s *reorgTracker) revertToCommonAncestor(ctx context.Context) (height int64, err error) {
+	for len(s.recent) > 0 {
+		newest := s.recent[len(s.recent)-1]
+
+		var header *types.Header
+		_, err = s.opts.Retry.Do(ctx, func(ctx context.Context) (err error) {
+			header, err = s.client.HeaderByNumber(ctx, newest.Block.Number())
+			return err
+		})
+		if err != nil {
+			return height, err
+		}
+
+		if header.Hash() == newest.Block.Hash() {
+			return newest.Block.Number().Int64(), nil
+		}
+
+		if err := s.send(ctx, BlockEvent{Type: BlockEventRevert, Block: newest}); err != nil {
+			return height, err
+		}
+		s.recent = s.recent[:len(s.recent)-1]
+		s.nextHeight = newest.Block.Number().Int64()
+	}
+	return height, ErrReorgTooDeep
+}
+
+func (s *reorgTracker) add(ctx context.Context, block *BlockWithTxReceipts) error {
+	if err := s.send(ctx, BlockEvent{Type: BlockEventNew, Block: block}); err != nil {
+		return err
+	}
+
+	s.recent = append(s.recent, block)
+	if len(s.recent) > s.maxDepth() {
+		s.recent = s.recent[1:]
+	}
+	s.nextHeight = block.Block.Number().Int64() + 1
+	return nil
+}
+
+func (s *reorgTracker) send(ctx context.Context, event BlockEvent) error {
+	select {
+	case s.eventChan <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
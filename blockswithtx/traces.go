@@ -0,0 +1,279 @@
+package blockswithtx
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/ethrpc"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+// TraceMethod is the JSON-RPC method used to get the call traces of a block
+type TraceMethod string
+
+const (
+	TraceMethodAuto        TraceMethod = ""                         // debug_traceBlockByNumber, or trace_block if the node doesn't support it
+	TraceMethodDebug       TraceMethod = "debug_traceBlockByNumber" // geth and most nodes, with the callTracer
+	TraceMethodParityTrace TraceMethod = "trace_block"              // Erigon, Nethermind, OpenEthereum
+)
+
+// CallFrame is a call (or contract creation/selfdestruct) of a transaction, with the calls it made in turn. The
+// top-level frame is the transaction itself.
+type CallFrame struct {
+	Type    string // CALL, STATICCALL, DELEGATECALL, CALLCODE, CREATE, CREATE2 or SELFDESTRUCT
+	From    common.Address
+	To      common.Address // the created contract for CREATE/CREATE2, the beneficiary for SELFDESTRUCT
+	Value   *big.Int       // nil if the call type can't transfer value
+	Gas     uint64
+	GasUsed uint64
+	Input   []byte
+	Output  []byte
+	Error   string // eg. "execution reverted", empty if the call succeeded
+	Calls   []*CallFrame
+}
+
+// Walk calls fn for this frame and all nested frames (depth-first, in call order). The top-level frame has depth 0.
+func (f *CallFrame) Walk(fn func(frame *CallFrame, depth int)) {
+	f.walk(fn, 0)
+}
+
+func (f *CallFrame) walk(fn func(frame *CallFrame, depth int), depth int) {
+	fn(f, depth)
+	for _, call := range f.Calls {
+		call.walk(fn, depth+1)
+	}
+}
+
+// InternalValueTransfers returns all nested frames which moved ETH ("internal transactions"). Frames which failed, or
+// are below a failed frame, are left out since their transfers were reverted.
+func (f *CallFrame) InternalValueTransfers() (transfers []*CallFrame) {
+	var collect func(frame *CallFrame, depth int)
+	collect = func(frame *CallFrame, depth int) {
+		if frame.Error != "" {
+			return
+		}
+		if depth > 0 && frame.Value != nil && frame.Value.Sign() > 0 {
+			transfers = append(transfers, frame)
+		}
+		for _, call := range frame.Calls {
+			collect(call, depth+1)
+		}
+	}
+	collect(f, 0)
+	return transfers
+}
+
+// getBlockTraces fetches the call traces of all transactions in the block. Returns the highest number of attempts any
+// call needed.
+func getBlockTraces(ctx context.Context, caller ethrpc.RPCCaller, block *types.Block, method TraceMethod, support *MethodSupport, retry utils.RetryPolicy) (traces map[common.Hash]*CallFrame, attempts int, err error) {
+	traces = make(map[common.Hash]*CallFrame)
+	if len(block.Transactions()) == 0 {
+		return traces, 0, nil
+	}
+
+	methods := []TraceMethod{method}
+	if method == TraceMethodAuto {
+		methods = []TraceMethod{TraceMethodDebug, TraceMethodParityTrace}
+		if supported := support.getTraceMethod(); supported != TraceMethodAuto {
+			methods = []TraceMethod{supported}
+		}
+	}
+
+	for _, m := range methods {
+		var frames []*CallFrame
+		attempts, err = retry.Do(ctx, func(ctx context.Context) (err error) {
+			if m == TraceMethodParityTrace {
+				frames, err = traceBlockParity(ctx, caller, block)
+			} else {
+				frames, err = traceBlockDebug(ctx, caller, block)
+			}
+			return err
+		})
+		if ethrpc.IsMethodNotSupported(err) && method == TraceMethodAuto {
+			continue
+		}
+		if err != nil {
+			return traces, attempts, err
+		}
+
+		if method == TraceMethodAuto {
+			support.setTraceMethod(m)
+		}
+		if len(frames) != len(block.Transactions()) {
+			return traces, attempts, fmt.Errorf("%s returned %d traces for %d transactions in block %s", m, len(frames), len(block.Transactions()), block.Hash())
+		}
+		for i, tx := range block.Transactions() {
+			traces[tx.Hash()] = frames[i]
+		}
+		return traces, attempts, nil
+	}
+	return traces, attempts, err
+}
+
+// callTracerFrame is the JSON format of geth's callTracer
+type callTracerFrame struct {
+	Type    string            `json:"type"`
+	From    common.Address    `json:"from"`
+	To      common.Address    `json:"to"`
+	Value   *hexutil.Big      `json:"value"`
+	Gas     hexutil.Uint64    `json:"gas"`
+	GasUsed hexutil.Uint64    `json:"gasUsed"`
+	Input   hexutil.Bytes     `json:"input"`
+	Output  hexutil.Bytes     `json:"output"`
+	Error   string            `json:"error"`
+	Calls   []callTracerFrame `json:"calls"`
+}
+
+func (c callTracerFrame) toCallFrame() *CallFrame {
+	frame := &CallFrame{
+		Type:    strings.ToUpper(c.Type),
+		From:    c.From,
+		To:      c.To,
+		Value:   (*big.Int)(c.Value),
+		Gas:     uint64(c.Gas),
+		GasUsed: uint64(c.GasUsed),
+		Input:   c.Input,
+		Output:  c.Output,
+		Error:   c.Error,
+	}
+	for _, call := range c.Calls {
+		frame.Calls = append(frame.Calls, call.toCallFrame())
+	}
+	return frame
+}
+
+func traceBlockDebug(ctx context.Context, caller ethrpc.RPCCaller, block *types.Block) ([]*CallFrame, error) {
+	var results []struct {
+		Result *callTracerFrame `json:"result"`
+		Error  string           `json:"error"`
+	}
+	err := caller.CallContext(ctx, &results, string(TraceMethodDebug), hexutil.EncodeBig(block.Number()), map[string]string{"tracer": "callTracer"})
+	if err != nil {
+		return nil, err
+	}
+
+	frames := make([]*CallFrame, len(results))
+	for i, res := range results {
+		if res.Result == nil {
+			return nil, fmt.Errorf("tracing tx %d of block %d failed: %s", i, block.NumberU64(), res.Error)
+		}
+		frames[i] = res.Result.toCallFrame()
+	}
+	return frames, nil
+}
+
+// parityTrace is an element of the trace_block response (a flat list of all calls of all transactions)
+type parityTrace struct {
+	Type   string `json:"type"` // call, create, suicide or reward
+	Action struct {
+		CallType      string         `json:"callType"`
+		From          common.Address `json:"from"`
+		To            common.Address `json:"to"`
+		Value         *hexutil.Big   `json:"value"`
+		Gas           hexutil.Uint64 `json:"gas"`
+		Input         hexutil.Bytes  `json:"input"`
+		Init          hexutil.Bytes  `json:"init"`
+		Address       common.Address `json:"address"`       // suicide
+		RefundAddress common.Address `json:"refundAddress"` // suicide
+		Balance       *hexutil.Big   `json:"balance"`       // suicide
+	} `json:"action"`
+	Result *struct {
+		GasUsed hexutil.Uint64 `json:"gasUsed"`
+		Output  hexutil.Bytes  `json:"output"`
+		Address common.Address `json:"address"` // create
+	} `json:"result"`
+	Error               string  `json:"error"`
+	TraceAddress        []int   `json:"traceAddress"`
+	TransactionPosition *uint64 `json:"transactionPosition"`
+}
+
+func (t parityTrace) toCallFrame() *CallFrame {
+	frame := &CallFrame{Error: t.Error}
+	switch t.Type {
+	case "create":
+		frame.Type = "CREATE"
+		frame.From = t.Action.From
+		frame.Value = (*big.Int)(t.Action.Value)
+		frame.Gas = uint64(t.Action.Gas)
+		frame.Input = t.Action.Init
+	case "suicide":
+		frame.Type = "SELFDESTRUCT"
+		frame.From = t.Action.Address
+		frame.To = t.Action.RefundAddress
+		frame.Value = (*big.Int)(t.Action.Balance)
+	default:
+		frame.Type = strings.ToUpper(t.Action.CallType)
+		frame.From = t.Action.From
+		frame.To = t.Action.To
+		frame.Value = (*big.Int)(t.Action.Value)
+		frame.Gas = uint64(t.Action.Gas)
+		frame.Input = t.Action.Input
+	}
+
+	if t.Result != nil {
+		frame.GasUsed = uint64(t.Result.GasUsed)
+		frame.Output = t.Result.Output
+		if t.Type == "create" {
+			frame.To = t.Result.Address
+		}
+	}
+	return frame
+}
+
+func traceBlockParity(ctx context.Context, caller ethrpc.RPCCaller, block *types.Block) ([]*CallFrame, error) {
+	var traces []parityTrace
+	if err := caller.CallContext(ctx, &traces, string(TraceMethodParityTrace), hexutil.EncodeBig(block.Number())); err != nil {
+		return nil, err
+	}
+
+	// Sorting the traces of each transaction by traceAddress puts every frame after its parent, and siblings in call order
+	byTx := make(map[uint64][]parityTrace)
+	for _, trace := range traces {
+		if trace.TransactionPosition == nil || trace.Type == "reward" {
+			continue
+		}
+		byTx[*trace.TransactionPosition] = append(byTx[*trace.TransactionPosition], trace)
+	}
+
+	frames := make([]*CallFrame, len(byTx))
+	for txIdx, txTraces := range byTx {
+		if txIdx >= uint64(len(frames)) {
+			return nil, fmt.Errorf("trace_block returned traces for tx %d, but block %d only has traces for %d txs", txIdx, block.NumberU64(), len(frames))
+		}
+		sort.SliceStable(txTraces, func(i, j int) bool { return lessTraceAddress(txTraces[i].TraceAddress, txTraces[j].TraceAddress) })
+
+		nodes := make(map[string]*CallFrame)
+		for _, trace := range txTraces {
+			frame := trace.toCallFrame()
+			nodes[fmt.Sprint(trace.TraceAddress)] = frame
+			if len(trace.TraceAddress) == 0 {
+				frames[txIdx] = frame
+				continue
+			}
+			parent, found := nodes[fmt.Sprint(trace.TraceAddress[:len(trace.TraceAddress)-1])]
+			if !found {
+				return nil, fmt.Errorf("trace_block: missing parent of trace %v in tx %d of block %d", trace.TraceAddress, txIdx, block.NumberU64())
+			}
+			parent.Calls = append(parent.Calls, frame)
+		}
+		if frames[txIdx] == nil {
+			return nil, fmt.Errorf("trace_block: missing top-level trace of tx %d in block %d", txIdx, block.NumberU64())
+		}
+	}
+	return frames, nil
+}
+
+func lessTraceAddress(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
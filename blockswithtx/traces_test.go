@@ -0,0 +1,91 @@
+package blockswithtx_test
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/metachris/go-ethutils/blockswithtx"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// Traces of the 3 transactions in block 12323931 (0xbc0c5b). The second one calls a contract, which sends 1 ETH on and
+// makes a reverted call with value.
+const debugTraces = `[
+	{"result": {"type": "CALL", "from": "0x1000000000000000000000000000000000000001", "to": "0x2000000000000000000000000000000000000002", "value": "0x1", "gas": "0x5208", "gasUsed": "0x5208", "input": "0x"}},
+	{"result": {"type": "CALL", "from": "0x1000000000000000000000000000000000000001", "to": "0x3000000000000000000000000000000000000003", "value": "0xde0b6b3a7640000", "gas": "0x30000", "gasUsed": "0x10000", "input": "0x12345678", "output": "0x", "calls": [
+		{"type": "CALL", "from": "0x3000000000000000000000000000000000000003", "to": "0x4000000000000000000000000000000000000004", "value": "0xde0b6b3a7640000", "gas": "0x8fc", "gasUsed": "0x0", "input": "0x"},
+		{"type": "STATICCALL", "from": "0x3000000000000000000000000000000000000003", "to": "0x5000000000000000000000000000000000000005", "gas": "0x1000", "gasUsed": "0x100", "input": "0x70a08231", "output": "0x01"},
+		{"type": "CALL", "from": "0x3000000000000000000000000000000000000003", "to": "0x6000000000000000000000000000000000000006", "value": "0x5", "gas": "0x1000", "gasUsed": "0x1000", "input": "0x", "error": "execution reverted"}
+	]}},
+	{"result": {"type": "CREATE", "from": "0x1000000000000000000000000000000000000001", "to": "0x7000000000000000000000000000000000000007", "value": "0x0", "gas": "0x50000", "gasUsed": "0x40000", "input": "0x6080", "output": "0x6080"}}
+]`
+
+// The same traces in the trace_block format (in a different order, to check the tree is built from traceAddress)
+const parityTraces = `[
+	{"type": "call", "action": {"callType": "call", "from": "0x1000000000000000000000000000000000000001", "to": "0x2000000000000000000000000000000000000002", "value": "0x1", "gas": "0x5208", "input": "0x"}, "result": {"gasUsed": "0x5208", "output": "0x"}, "traceAddress": [], "subtraces": 0, "transactionPosition": 0},
+	{"type": "call", "action": {"callType": "staticcall", "from": "0x3000000000000000000000000000000000000003", "to": "0x5000000000000000000000000000000000000005", "value": "0x0", "gas": "0x1000", "input": "0x70a08231"}, "result": {"gasUsed": "0x100", "output": "0x01"}, "traceAddress": [1], "subtraces": 0, "transactionPosition": 1},
+	{"type": "call", "action": {"callType": "call", "from": "0x1000000000000000000000000000000000000001", "to": "0x3000000000000000000000000000000000000003", "value": "0xde0b6b3a7640000", "gas": "0x30000", "input": "0x12345678"}, "result": {"gasUsed": "0x10000", "output": "0x"}, "traceAddress": [], "subtraces": 3, "transactionPosition": 1},
+	{"type": "call", "action": {"callType": "call", "from": "0x3000000000000000000000000000000000000003", "to": "0x4000000000000000000000000000000000000004", "value": "0xde0b6b3a7640000", "gas": "0x8fc", "input": "0x"}, "result": {"gasUsed": "0x0", "output": "0x"}, "traceAddress": [0], "subtraces": 0, "transactionPosition": 1},
+	{"type": "call", "action": {"callType": "call", "from": "0x3000000000000000000000000000000000000003", "to": "0x6000000000000000000000000000000000000006", "value": "0x5", "gas": "0x1000", "input": "0x"}, "error": "Reverted", "traceAddress": [2], "subtraces": 0, "transactionPosition": 1},
+	{"type": "create", "action": {"from": "0x1000000000000000000000000000000000000001", "value": "0x0", "gas": "0x50000", "init": "0x6080"}, "result": {"gasUsed": "0x40000", "code": "0x6080", "address": "0x7000000000000000000000000000000000000007"}, "traceAddress": [], "subtraces": 0, "transactionPosition": 2},
+	{"type": "reward", "action": {"author": "0x00000000000000000000000000000000000c0b5e", "rewardType": "block", "value": "0x1bc16d674ec80000"}, "result": null, "traceAddress": [], "subtraces": 0, "transactionPosition": null}
+]`
+
+func TestGetBlockWithTxReceiptsTraces(t *testing.T) {
+	for _, method := range []blockswithtx.TraceMethod{blockswithtx.TraceMethodDebug, blockswithtx.TraceMethodParityTrace} {
+		server := newChainServer(t)
+		server.Fixtures.Add("debug_traceBlockByNumber", []interface{}{"0xbc0c5b", map[string]string{"tracer": "callTracer"}}, json.RawMessage(debugTraces))
+		server.Fixtures.Add("trace_block", []interface{}{"0xbc0c5b"}, json.RawMessage(parityTraces))
+		if method == blockswithtx.TraceMethodParityTrace {
+			server.Fixtures.RemoveMethod("debug_traceBlockByNumber") // detected automatically
+		}
+
+		client, err := ethrpc.Dial(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		// The trace method is only detected once
+		opts := blockswithtx.Options{Traces: true, MethodSupport: &blockswithtx.MethodSupport{}}
+		var b *blockswithtx.BlockWithTxReceipts
+		for i := 0; i < 2; i++ {
+			if b, err = blockswithtx.GetBlockWithTxReceiptsWithOptions(context.Background(), client, 12323931, opts); err != nil {
+				t.Fatalf("%s: %v", method, err)
+			}
+		}
+		if n := server.Calls("debug_traceBlockByNumber"); (method == blockswithtx.TraceMethodDebug && n != 2) || (method == blockswithtx.TraceMethodParityTrace && n != 1) {
+			t.Errorf("%s: debug_traceBlockByNumber called %d times", method, n)
+		}
+		if len(b.TxTraces) != 3 {
+			t.Fatalf("%s: got %d traces, expected 3", method, len(b.TxTraces))
+		}
+
+		trace := b.TxTraces[b.Block.Transactions()[1].Hash()]
+		if trace.Type != "CALL" || trace.To != common.HexToAddress("0x3000000000000000000000000000000000000003") || len(trace.Calls) != 3 {
+			t.Fatalf("%s: unexpected trace %+v", method, trace)
+		}
+		if trace.Calls[1].Type != "STATICCALL" || trace.Calls[1].GasUsed != 0x100 || trace.Calls[2].Error == "" {
+			t.Errorf("%s: unexpected nested calls %+v %+v", method, trace.Calls[1], trace.Calls[2])
+		}
+
+		transfers := trace.InternalValueTransfers()
+		if len(transfers) != 1 || transfers[0].To != common.HexToAddress("0x4000000000000000000000000000000000000004") || transfers[0].Value.Cmp(big.NewInt(1e18)) != 0 {
+			t.Errorf("%s: unexpected internal transfers %+v", method, transfers)
+		}
+
+		depths := []int{}
+		trace.Walk(func(frame *blockswithtx.CallFrame, depth int) { depths = append(depths, depth) })
+		if len(depths) != 4 || depths[0] != 0 || depths[3] != 1 {
+			t.Errorf("%s: unexpected walk %v", method, depths)
+		}
+
+		create := b.TxTraces[b.Block.Transactions()[2].Hash()]
+		if create.Type != "CREATE" || create.To != common.HexToAddress("0x7000000000000000000000000000000000000007") {
+			t.Errorf("%s: unexpected create trace %+v", method, create)
+		}
+	}
+}
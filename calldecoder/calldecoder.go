@@ -0,0 +1,205 @@
+// Decode transaction calldata into method names and arguments, using user-provided ABIs and a bundled database of
+// function signatures
+package calldecoder
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/abijson"
+)
+
+//go:embed signatures.txt
+var bundledSignatures []byte
+
+var (
+	ErrNoSelector      = errors.New("calldata shorter than 4 bytes")
+	ErrUnknownSelector = errors.New("unknown function selector")
+)
+
+// DecodedCall is calldata decoded with the matching method
+type DecodedCall struct {
+	Name      string // eg. "transfer"
+	Signature string // eg. "transfer(address,uint256)"
+	Selector  [4]byte
+	Args      map[string]interface{} // by argument name (signature database methods have arg0, arg1, ...)
+	Values    []interface{}          // in argument order
+	Method    abi.Method
+	FromABI   bool // the method comes from a user-provided ABI (with argument names), not the signature database
+}
+
+func (c *DecodedCall) String() string {
+	return fmt.Sprintf("%s%v", c.Name, c.Values)
+}
+
+// Decoder decodes calldata. Methods of user-provided ABIs take priority over the signature database. If several
+// methods have the same selector (collisions are common in the 4byte database), all of them are tried.
+type Decoder struct {
+	lock       sync.RWMutex
+	abiMethods map[[4]byte][]abi.Method
+	signatures map[[4]byte][]abi.Method
+}
+
+// NewDecoder returns a decoder with the bundled signature database (common ERC20/721/1155, Uniswap, Multicall and
+// Gnosis Safe methods)
+func NewDecoder() *Decoder {
+	d := NewEmptyDecoder()
+	if err := d.AddSignatures(bundledSignatures); err != nil {
+		panic(err) // only possible if signatures.txt is broken
+	}
+	return d
+}
+
+// NewEmptyDecoder returns a decoder without any methods
+func NewEmptyDecoder() *Decoder {
+	return &Decoder{
+		abiMethods: make(map[[4]byte][]abi.Method),
+		signatures: make(map[[4]byte][]abi.Method),
+	}
+}
+
+func addMethod(methods map[[4]byte][]abi.Method, method abi.Method) {
+	var selector [4]byte
+	copy(selector[:], method.ID)
+	for _, existing := range methods[selector] {
+		if existing.Sig == method.Sig {
+			return
+		}
+	}
+	methods[selector] = append(methods[selector], method)
+}
+
+// AddABI registers all methods of an ABI
+func (d *Decoder) AddABI(contractAbi abi.ABI) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, method := range contractAbi.Methods {
+		addMethod(d.abiMethods, method)
+	}
+}
+
+// AddABIJSON registers all methods of an ABI in JSON format (see abijson.Parse)
+func (d *Decoder) AddABIJSON(data []byte) error {
+	contractAbi, err := abijson.Parse(data)
+	if err != nil {
+		return err
+	}
+	d.AddABI(contractAbi)
+	return nil
+}
+
+// AddABIFile registers all methods of an ABI JSON file
+func (d *Decoder) AddABIFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := d.AddABIJSON(data); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// AddSignatures adds a list of text signatures to the signature database, one per line (eg. "transfer(address,uint256)",
+// optionally prefixed with the selector like in 4byte.directory exports)
+func (d *Decoder) AddSignatures(data []byte) error {
+	methods, err := readSignatures(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for _, method := range methods {
+		addMethod(d.signatures, method)
+	}
+	return nil
+}
+
+// AddSignaturesFile adds the signatures of a file to the signature database (see AddSignatures)
+func (d *Decoder) AddSignaturesFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := d.AddSignatures(data); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// Methods returns the candidate methods for a selector, the ones from ABIs first
+func (d *Decoder) Methods(selector [4]byte) []abi.Method {
+	methods, _ := d.candidates(selector)
+	return methods
+}
+
+// candidates returns the candidate methods for a selector, and how many of them are from ABIs
+func (d *Decoder) candidates(selector [4]byte) (methods []abi.Method, numAbiMethods int) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	methods = make([]abi.Method, 0, len(d.abiMethods[selector])+len(d.signatures[selector]))
+	methods = append(methods, d.abiMethods[selector]...)
+	return append(methods, d.signatures[selector]...), len(d.abiMethods[selector])
+}
+
+// Decode decodes calldata (4-byte selector followed by the ABI-encoded arguments). Of the candidate methods, the
+// first one whose re-encoded arguments equal the calldata wins; if none matches exactly (eg. calldata with trailing
+// bytes), the first one which can decode the arguments.
+func (d *Decoder) Decode(data []byte) (*DecodedCall, error) {
+	if len(data) < 4 {
+		return nil, ErrNoSelector
+	}
+
+	var selector [4]byte
+	copy(selector[:], data[:4])
+	candidates, numAbiMethods := d.candidates(selector)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSelector, hexutil.Encode(selector[:]))
+	}
+
+	var fallback *DecodedCall
+	for i, method := range candidates {
+		values, err := method.Inputs.Unpack(data[4:])
+		if err != nil {
+			continue
+		}
+
+		call := &DecodedCall{
+			Name:      method.RawName,
+			Signature: method.Sig,
+			Selector:  selector,
+			Args:      make(map[string]interface{}),
+			Values:    values,
+			Method:    method,
+			FromABI:   i < numAbiMethods,
+		}
+		for j, input := range method.Inputs {
+			call.Args[input.Name] = values[j]
+		}
+
+		if packed, err := method.Inputs.Pack(values...); err == nil && bytes.Equal(packed, data[4:]) {
+			return call, nil
+		}
+		if fallback == nil {
+			fallback = call
+		}
+	}
+
+	if fallback == nil {
+		return nil, fmt.Errorf("calldata doesn't match any of the %d methods with selector %s", len(candidates), hexutil.Encode(selector[:]))
+	}
+	return fallback, nil
+}
+
+// DecodeTransaction decodes the calldata of a transaction. Plain ETH transfers return ErrNoSelector.
+func (d *Decoder) DecodeTransaction(tx *types.Transaction) (*DecodedCall, error) {
+	return d.Decode(tx.Data())
+}
@@ -0,0 +1,132 @@
+package calldecoder_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/eth-go-bindings/erc20"
+	"github.com/metachris/go-ethutils/calldecoder"
+	"github.com/metachris/go-ethutils/rpctest"
+)
+
+var bob = common.HexToAddress("0x2000000000000000000000000000000000000002")
+
+func transferCalldata(amount int64) []byte {
+	data := common.FromHex("0xa9059cbb")
+	data = append(data, common.LeftPadBytes(bob.Bytes(), 32)...)
+	return append(data, common.LeftPadBytes(big.NewInt(amount).Bytes(), 32)...)
+}
+
+func TestDecode(t *testing.T) {
+	d := calldecoder.NewDecoder()
+
+	// join_tg_invmru_haha_fd06787(address,bool) has the same selector as transfer(address,uint256)
+	if err := d.AddSignatures([]byte("0xa9059cbb join_tg_invmru_haha_fd06787(address,bool)\n")); err != nil {
+		t.Fatal(err)
+	}
+	if methods := d.Methods([4]byte{0xa9, 0x05, 0x9c, 0xbb}); len(methods) != 2 {
+		t.Fatalf("got %d methods for 0xa9059cbb, expected 2", len(methods))
+	}
+
+	call, err := d.Decode(transferCalldata(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Signature != "transfer(address,uint256)" || call.FromABI || call.Args["arg0"] != bob || call.Args["arg1"].(*big.Int).Int64() != 500 {
+		t.Errorf("unexpected call %s (%s)", call, call.Signature)
+	}
+
+	// Both candidates can decode an amount of 1, the first exact match (in database order) wins
+	call, err = d.Decode(transferCalldata(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Name != "transfer" {
+		t.Errorf("unexpected call %s", call.Signature)
+	}
+
+	// User-provided ABIs have priority, and have argument names
+	if err := d.AddABIJSON([]byte(erc20.Erc20ABI)); err != nil {
+		t.Fatal(err)
+	}
+	call, err = d.Decode(transferCalldata(500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !call.FromABI || call.Args["recipient"] != bob || call.Args["amount"].(*big.Int).Int64() != 500 {
+		t.Errorf("unexpected call %s (%v)", call, call.Args)
+	}
+
+	if _, err := d.Decode(common.FromHex("0x12345678")); !errors.Is(err, calldecoder.ErrUnknownSelector) {
+		t.Errorf("expected ErrUnknownSelector, got %v", err)
+	}
+	if _, err := d.Decode(nil); !errors.Is(err, calldecoder.ErrNoSelector) {
+		t.Errorf("expected ErrNoSelector, got %v", err)
+	}
+}
+
+func TestParseSignature(t *testing.T) {
+	method, err := calldecoder.ParseSignature("aggregate3((address,bool,bytes)[])")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method.Sig != "aggregate3((address,bool,bytes)[])" || common.Bytes2Hex(method.ID) != "82ad56cb" {
+		t.Errorf("unexpected method %s (0x%x)", method.Sig, method.ID)
+	}
+
+	// Round trip of a call with a tuple argument
+	type params struct {
+		Field0 common.Address
+		Field1 bool
+		Field2 []byte
+	}
+	calls := []params{{bob, true, []byte{1, 2, 3}}}
+	packed, err := method.Inputs.Pack(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, err := calldecoder.NewDecoder().Decode(append(method.ID, packed...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Name != "aggregate3" || len(call.Values) != 1 {
+		t.Errorf("unexpected call %s", call)
+	}
+
+	for _, invalid := range []string{"transfer", "transfer(address,uint256", "(address)", "f(notatype)"} {
+		if _, err := calldecoder.ParseSignature(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestDecodeTransaction(t *testing.T) {
+	fixtures, err := rpctest.LoadFixtures("../rpctest/testdata/chain.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	defer server.Close()
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The second transaction of block 12323931 is a token transfer
+	block, err := client.BlockByNumber(context.Background(), big.NewInt(12323931))
+	if err != nil {
+		t.Fatal(err)
+	}
+	call, err := calldecoder.NewDecoder().DecodeTransaction(block.Transactions()[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Signature != "transfer(address,uint256)" || call.Args["arg1"].(*big.Int).Int64() != 12323931000 {
+		t.Errorf("unexpected call %s", call)
+	}
+}
@@ -0,0 +1,106 @@
+package calldecoder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// ParseSignature turns a text signature (eg. "transfer(address,uint256)", or with tuples
+// "exactInput((bytes,address,uint256,uint256,uint256))") into a method. The arguments are named arg0, arg1, ...
+func ParseSignature(signature string) (abi.Method, error) {
+	signature = strings.TrimSpace(signature)
+	open := strings.Index(signature, "(")
+	if open < 1 || !strings.HasSuffix(signature, ")") {
+		return abi.Method{}, fmt.Errorf("invalid signature %q", signature)
+	}
+
+	name := signature[:open]
+	components, err := parseTypeList(signature[open+1:len(signature)-1], "arg")
+	if err != nil {
+		return abi.Method{}, fmt.Errorf("invalid signature %q: %v", signature, err)
+	}
+
+	inputs := make(abi.Arguments, len(components))
+	for i, c := range components {
+		typ, err := abi.NewType(c.Type, "", c.Components)
+		if err != nil {
+			return abi.Method{}, fmt.Errorf("invalid signature %q: %v", signature, err)
+		}
+		inputs[i] = abi.Argument{Name: c.Name, Type: typ}
+	}
+	return abi.NewMethod(name, name, abi.Function, "", false, false, inputs, nil), nil
+}
+
+// parseTypeList parses comma-separated types (the inside of the parentheses), tuples become "tuple" types with
+// components
+func parseTypeList(list string, namePrefix string) (args []abi.ArgumentMarshaling, err error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	for _, part := range splitTopLevel(list) {
+		arg := abi.ArgumentMarshaling{Name: fmt.Sprintf("%s%d", namePrefix, len(args))}
+		if strings.HasPrefix(part, "(") {
+			end := strings.LastIndex(part, ")")
+			if end < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in %q", part)
+			}
+			arg.Components, err = parseTypeList(part[1:end], "field")
+			if err != nil {
+				return nil, err
+			}
+			arg.Type = "tuple" + part[end+1:] // array suffix, eg. "(address,bytes)[]"
+		} else {
+			arg.Type = part
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// splitTopLevel splits at the commas which are not inside parentheses
+func splitTopLevel(list string) (parts []string) {
+	depth, start := 0, 0
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(list[start:]))
+}
+
+// readSignatures reads a signature list: one signature per line, optionally prefixed with the selector (the format of
+// 4byte.directory exports). Empty lines and lines starting with # are skipped.
+func readSignatures(r io.Reader) (methods []abi.Method, err error) {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) == 2 && strings.HasPrefix(fields[0], "0x") {
+			line = fields[1]
+		}
+
+		method, err := ParseSignature(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		methods = append(methods, method)
+	}
+	return methods, scanner.Err()
+}
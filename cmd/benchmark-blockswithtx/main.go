@@ -1,13 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/metachris/go-ethutils/blockswithtx"
+	"github.com/metachris/go-ethutils/ethrpc"
+	"github.com/metachris/go-ethutils/metrics"
 	"github.com/metachris/go-ethutils/utils"
 )
 
@@ -19,30 +21,42 @@ func main() {
 
 	fmt.Println(ethNode, concurrency, startBlock, numBlocks)
 
-	// Connect the geth client
-	client, err := ethclient.Dial(ethNode)
+	// Connect the geth client. ethrpc.Client also exposes the raw RPC connection, which is used to fetch all receipts
+	// of a block in a single call.
+	client, err := ethrpc.Dial(ethNode)
 	utils.Perror(err)
 
+	// Collect metrics of the pipeline and all RPC calls, and print the progress every few seconds
+	collector := metrics.NewCollector()
+	backend := ethrpc.NewInstrumentedBackend(client, collector)
+	ctx, cancel := context.WithCancel(context.Background())
+	go collector.Report(ctx, os.Stdout, 2*time.Second)
+
 	// Create the channel to receive BlockWithTxReceipt
 	blockChan := make(chan *blockswithtx.BlockWithTxReceipts, 100)
 
 	// Create worker thread to process received items
 	var lock sync.Mutex
-	var numTx int64
 	go func() {
 		lock.Lock()
 		defer lock.Unlock()
 		for b := range blockChan {
-			numTx += int64(len(b.Block.Transactions()))
 			fmt.Println(b.Block.Number())
 		}
 	}()
 
-	// Time retrieving the data
-	t1 := time.Now()
-	blockswithtx.GetBlocksWithTxReceipts(client, blockChan, startBlock, startBlock+numBlocks, concurrency)
+	opts := blockswithtx.Options{PipelineOptions: utils.PipelineOptions{Concurrency: concurrency, Observer: collector}}
+	err = blockswithtx.GetBlocksWithTxReceiptsWithOptions(ctx, backend, blockChan, startBlock, startBlock+numBlocks, opts)
 	close(blockChan)
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
 	lock.Lock() // wait until all blocks have been processed
-	t2 := time.Since(t1)
-	fmt.Printf("Processed %d transactions in %.3f seconds (%.2f tx/sec)\n", numTx, t2.Seconds(), float64(numTx)/t2.Seconds())
+	cancel()
+
+	stats := collector.Stats()
+	fmt.Printf("Processed %d transactions in %.3f seconds (%.2f tx/sec)\n", stats.Items, stats.Elapsed.Seconds(), stats.ItemsPerSecond())
+	for method, rpcStats := range stats.RPC {
+		fmt.Printf("- %s: %d calls, %d errors, avg %.1f ms\n", method, rpcStats.Calls, rpcStats.Errors, rpcStats.Duration.Sum/float64(rpcStats.Calls)*1000)
+	}
 }
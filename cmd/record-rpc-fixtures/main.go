@@ -0,0 +1,44 @@
+// Records JSON-RPC responses of a node into a fixtures file for rpctest. Point the code under test at the printed URL,
+// then stop with Ctrl+C to save.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/metachris/go-ethutils/rpctest"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+func main() {
+	log.SetOutput(os.Stdout)
+
+	upstreamPtr := flag.String("eth", os.Getenv("ETH_NODE"), "Ethereum node to record (default: ETH_NODE env var)")
+	outPtr := flag.String("out", "fixtures.json", "Fixtures file (existing fixtures are kept)")
+	flag.Parse()
+
+	if *upstreamPtr == "" {
+		log.Fatal("Missing -eth argument or ETH_NODE env var")
+	}
+
+	fixtures := rpctest.NewFixtures()
+	if _, err := os.Stat(*outPtr); err == nil {
+		fixtures, err = rpctest.LoadFixtures(*outPtr)
+		utils.Perror(err)
+	}
+
+	server, err := rpctest.NewRecordingServer(fixtures, *upstreamPtr)
+	utils.Perror(err)
+	fmt.Println("Recording server:", server.URL)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	<-sigChan
+
+	server.Close()
+	utils.Perror(fixtures.Save(*outPtr))
+	fmt.Println("Saved fixtures to", *outPtr)
+}
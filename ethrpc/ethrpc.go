@@ -0,0 +1,218 @@
+// Interfaces and helpers for talking to Ethereum JSON-RPC endpoints
+package ethrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// HeaderReader can get block headers (nil number = latest block)
+type HeaderReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// BlockReader can get full blocks (nil number = latest block)
+type BlockReader interface {
+	BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+}
+
+// ReceiptReader can get transaction receipts
+type ReceiptReader interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+}
+
+// HeadSubscriber can subscribe to new chain heads. HTTP connections return rpc.ErrNotificationsUnsupported.
+type HeadSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// CodeReader can get the code of a contract
+type CodeReader interface {
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+}
+
+// ContractCaller can execute read-only contract calls
+type ContractCaller interface {
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// StorageReader can read storage slots of a contract
+type StorageReader interface {
+	StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error)
+}
+
+// LogFilterer can query logs (eth_getLogs)
+type LogFilterer interface {
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// ChainReader can read blocks and follow the chain head (used by the block pipelines in follow mode)
+type ChainReader interface {
+	HeaderReader
+	BlockReader
+	HeadSubscriber
+}
+
+// BlockReceiptReader can read blocks and their receipts (used by blockswithtx)
+type BlockReceiptReader interface {
+	BlockReader
+	ReceiptReader
+}
+
+// ChainReceiptReader can read blocks with receipts and follow the chain head (used by blockswithtx in follow mode)
+type ChainReceiptReader interface {
+	ChainReader
+	ReceiptReader
+}
+
+// ContractReader can read contract code and state. It has the same methods as bind.ContractCaller, so it can be used
+// with abigen contract bindings (eg. erc20.NewErc20Caller).
+type ContractReader interface {
+	CodeReader
+	ContractCaller
+}
+
+// ContractStorageReader can also read the storage of contracts (used for proxy detection)
+type ContractStorageReader interface {
+	ContractReader
+	StorageReader
+}
+
+// Backend combines all the interfaces above. It is implemented by *ethclient.Client, *Client, *Pool and
+// *RateLimitedBackend.
+type Backend interface {
+	ChainReceiptReader
+	ContractStorageReader
+	LogFilterer
+}
+
+// RPCCaller can make raw JSON-RPC calls. It is implemented by *rpc.Client (eg. the client an ethclient.Client was created from).
+type RPCCaller interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+}
+
+// RPCProvider is implemented by backends which can also make raw JSON-RPC calls (like *Client and *Pool). RPC
+// returns nil if that's not possible.
+type RPCProvider interface {
+	RPC() RPCCaller
+}
+
+// GetRPCCaller returns the raw JSON-RPC connection of a backend, or nil if it doesn't have one
+func GetRPCCaller(backend interface{}) RPCCaller {
+	switch b := backend.(type) {
+	case RPCProvider:
+		return b.RPC()
+	case RPCCaller:
+		return b
+	}
+	return nil
+}
+
+// Client is an ethclient.Client which also exposes its JSON-RPC connection, so that helpers can use batch requests
+// and methods ethclient doesn't know about (eg. eth_getBlockReceipts)
+type Client struct {
+	*ethclient.Client
+	rpc *rpc.Client
+}
+
+// Dial connects a client to the given URL (http, ws or IPC path)
+func Dial(rawurl string) (*Client, error) {
+	return DialContext(context.Background(), rawurl)
+}
+
+func DialContext(ctx context.Context, rawurl string) (*Client, error) {
+	c, err := rpc.DialContext(ctx, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(c), nil
+}
+
+// NewClient creates a client that uses the given RPC client
+func NewClient(c *rpc.Client) *Client {
+	return &Client{Client: ethclient.NewClient(c), rpc: c}
+}
+
+func (c *Client) RPC() RPCCaller {
+	return c.rpc
+}
+
+// IsMethodNotSupported returns true if err means that the node doesn't provide the called JSON-RPC method
+func IsMethodNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32601 {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"method not found", "does not exist/is not available", "method not supported", "unsupported method", "not implemented"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLogRangeTooLarge returns true if err means that the node rejected an eth_getLogs query because the block range is
+// too wide or the query has too many results. Querying a smaller range should work.
+func IsLogRangeTooLarge(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"query returned more than", "response size exceeded", "too many results", "block range is too", "block range too", "exceed maximum block range", "exceeds max block range", "range too large", "range is too large"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTransientError returns true for errors which are likely temporary or specific to the endpoint: network/connection
+// errors, timeouts, rate limiting and 5xx HTTP responses. Context cancellation, ethereum.NotFound and regular JSON-RPC
+// errors (eg. reverted calls, invalid params) and eth_getLogs queries over too large ranges are not transient.
+func IsTransientError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ethereum.NotFound) || IsLogRangeTooLarge(err) {
+		return false
+	}
+
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"connection reset", "connection refused", "broken pipe", "timeout", "timed out", "too many requests", "rate limit", "limit exceeded", "header not found"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return rpcErr.ErrorCode() == -32005 // limit exceeded (EIP-1474)
+	}
+
+	return false
+}
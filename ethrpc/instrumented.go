@@ -0,0 +1,107 @@
+package ethrpc
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// CallObserver is notified about every JSON-RPC call, eg. a *metrics.Collector. ObserveCall is called concurrently.
+type CallObserver interface {
+	ObserveCall(method string, duration time.Duration, err error)
+}
+
+// InstrumentedBackend is a Backend which reports every call to a CallObserver. If the wrapped backend can make raw
+// JSON-RPC calls, these are reported too (every element of a batch request with the duration of the whole batch).
+type InstrumentedBackend struct {
+	backend  Backend
+	observer CallObserver
+}
+
+func NewInstrumentedBackend(backend Backend, observer CallObserver) *InstrumentedBackend {
+	return &InstrumentedBackend{backend: backend, observer: observer}
+}
+
+func (b *InstrumentedBackend) observe(method string, start time.Time, err error) {
+	b.observer.ObserveCall(method, time.Since(start), err)
+}
+
+func (b *InstrumentedBackend) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	defer func(start time.Time) { b.observe("eth_getBlockByNumber", start, err) }(time.Now())
+	return b.backend.HeaderByNumber(ctx, number)
+}
+
+func (b *InstrumentedBackend) BlockByNumber(ctx context.Context, number *big.Int) (block *types.Block, err error) {
+	defer func(start time.Time) { b.observe("eth_getBlockByNumber", start, err) }(time.Now())
+	return b.backend.BlockByNumber(ctx, number)
+}
+
+func (b *InstrumentedBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (receipt *types.Receipt, err error) {
+	defer func(start time.Time) { b.observe("eth_getTransactionReceipt", start, err) }(time.Now())
+	return b.backend.TransactionReceipt(ctx, txHash)
+}
+
+func (b *InstrumentedBackend) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (sub ethereum.Subscription, err error) {
+	defer func(start time.Time) { b.observe("eth_subscribe", start, err) }(time.Now())
+	return b.backend.SubscribeNewHead(ctx, ch)
+}
+
+func (b *InstrumentedBackend) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) (code []byte, err error) {
+	defer func(start time.Time) { b.observe("eth_getCode", start, err) }(time.Now())
+	return b.backend.CodeAt(ctx, account, blockNumber)
+}
+
+func (b *InstrumentedBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) (result []byte, err error) {
+	defer func(start time.Time) { b.observe("eth_call", start, err) }(time.Now())
+	return b.backend.CallContract(ctx, call, blockNumber)
+}
+
+func (b *InstrumentedBackend) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (logs []types.Log, err error) {
+	defer func(start time.Time) { b.observe("eth_getLogs", start, err) }(time.Now())
+	return b.backend.FilterLogs(ctx, q)
+}
+
+func (b *InstrumentedBackend) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) (value []byte, err error) {
+	defer func(start time.Time) { b.observe("eth_getStorageAt", start, err) }(time.Now())
+	return b.backend.StorageAt(ctx, account, key, blockNumber)
+}
+
+// RPC returns the instrumented raw JSON-RPC connection of the wrapped backend, or nil if it doesn't have one
+func (b *InstrumentedBackend) RPC() RPCCaller {
+	caller := GetRPCCaller(b.backend)
+	if caller == nil {
+		return nil
+	}
+	return instrumentedRPC{caller: caller, observer: b.observer}
+}
+
+type instrumentedRPC struct {
+	caller   RPCCaller
+	observer CallObserver
+}
+
+func (r instrumentedRPC) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	start := time.Now()
+	err := r.caller.CallContext(ctx, result, method, args...)
+	r.observer.ObserveCall(method, time.Since(start), err)
+	return err
+}
+
+func (r instrumentedRPC) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	start := time.Now()
+	err := r.caller.BatchCallContext(ctx, b)
+	duration := time.Since(start)
+	for _, elem := range b {
+		elemErr := elem.Error
+		if err != nil {
+			elemErr = err
+		}
+		r.observer.ObserveCall(elem.Method, duration, elemErr)
+	}
+	return err
+}
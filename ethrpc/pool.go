@@ -0,0 +1,237 @@
+package ethrpc
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var ErrNoEndpoints = errors.New("no endpoints in pool")
+
+// PoolOptions configures when a Pool ejects an endpoint
+type PoolOptions struct {
+	MaxFailures   int           // number of consecutive failures after which an endpoint is ejected (default 3)
+	EjectDuration time.Duration // how long an ejected endpoint is not used (default 30s)
+	MaxLatency    time.Duration // calls slower than this count as failures (0 = no latency limit)
+}
+
+// Pool distributes requests round-robin across several endpoints. Endpoints are ejected for a while after repeated
+// transient errors (see IsTransientError) or slow responses, and a call that fails with a transient error is retried
+// on the next endpoint. If all endpoints are ejected, they are used anyway. Pool implements Backend and RPCProvider.
+type Pool struct {
+	endpoints []*poolEndpoint
+	opts      PoolOptions
+	next      uint64 // round-robin counter
+}
+
+type poolEndpoint struct {
+	backend Backend
+	rpc     RPCCaller
+
+	lock         sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+	latency      time.Duration // moving average
+}
+
+// NewPool creates a pool of the given backends. Raw JSON-RPC calls (Pool.RPC) are only possible if all backends
+// support them (eg. *Client).
+func NewPool(opts PoolOptions, backends ...Backend) *Pool {
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = 3
+	}
+	if opts.EjectDuration <= 0 {
+		opts.EjectDuration = 30 * time.Second
+	}
+
+	p := &Pool{opts: opts}
+	for _, backend := range backends {
+		p.endpoints = append(p.endpoints, &poolEndpoint{backend: backend, rpc: GetRPCCaller(backend)})
+	}
+	return p
+}
+
+// DialPool connects to all URLs and returns a pool of them
+func DialPool(ctx context.Context, opts PoolOptions, rawurls ...string) (*Pool, error) {
+	backends := make([]Backend, len(rawurls))
+	for i, rawurl := range rawurls {
+		client, err := DialContext(ctx, rawurl)
+		if err != nil {
+			return nil, err
+		}
+		backends[i] = client
+	}
+	return NewPool(opts, backends...), nil
+}
+
+// candidates returns the healthy endpoints in round-robin order, followed by the ejected ones
+func (p *Pool) candidates() []*poolEndpoint {
+	n := len(p.endpoints)
+	start := int(atomic.AddUint64(&p.next, 1) % uint64(n))
+
+	healthy := make([]*poolEndpoint, 0, n)
+	var ejected []*poolEndpoint
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		e := p.endpoints[(start+i)%n]
+		if e.isEjected(now) {
+			ejected = append(ejected, e)
+		} else {
+			healthy = append(healthy, e)
+		}
+	}
+	return append(healthy, ejected...)
+}
+
+// call runs fn with one endpoint after the other, until it succeeds or fails with an error that isn't transient
+func (p *Pool) call(ctx context.Context, fn func(e *poolEndpoint) error) (err error) {
+	if len(p.endpoints) == 0 {
+		return ErrNoEndpoints
+	}
+
+	for _, e := range p.candidates() {
+		start := time.Now()
+		err = fn(e)
+		e.record(time.Since(start), err, p.opts)
+		if err == nil || !IsTransientError(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (e *poolEndpoint) isEjected(now time.Time) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return now.Before(e.ejectedUntil)
+}
+
+func (e *poolEndpoint) record(latency time.Duration, err error, opts PoolOptions) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = (4*e.latency + latency) / 5
+	}
+
+	if IsTransientError(err) || (opts.MaxLatency > 0 && e.latency > opts.MaxLatency) {
+		e.failures++
+		if e.failures >= opts.MaxFailures {
+			e.ejectedUntil = time.Now().Add(opts.EjectDuration)
+			e.failures = 0
+			e.latency = 0 // start over after the ejection
+		}
+	} else if err == nil {
+		e.failures = 0
+	}
+}
+
+func (p *Pool) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = p.call(ctx, func(e *poolEndpoint) (err error) {
+		header, err = e.backend.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+func (p *Pool) BlockByNumber(ctx context.Context, number *big.Int) (block *types.Block, err error) {
+	err = p.call(ctx, func(e *poolEndpoint) (err error) {
+		block, err = e.backend.BlockByNumber(ctx, number)
+		return err
+	})
+	return block, err
+}
+
+func (p *Pool) TransactionReceipt(ctx context.Context, txHash common.Hash) (receipt *types.Receipt, err error) {
+	err = p.call(ctx, func(e *poolEndpoint) (err error) {
+		receipt, err = e.backend.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return receipt, err
+}
+
+// SubscribeNewHead subscribes on the first endpoint that supports subscriptions
+func (p *Pool) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (sub ethereum.Subscription, err error) {
+	if len(p.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	for _, e := range p.candidates() {
+		sub, err = e.backend.SubscribeNewHead(ctx, ch)
+		if err == nil || ctx.Err() != nil {
+			return sub, err
+		}
+		if !errors.Is(err, rpc.ErrNotificationsUnsupported) {
+			e.record(0, err, p.opts)
+		}
+	}
+	return sub, err
+}
+
+func (p *Pool) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) (code []byte, err error) {
+	err = p.call(ctx, func(e *poolEndpoint) (err error) {
+		code, err = e.backend.CodeAt(ctx, account, blockNumber)
+		return err
+	})
+	return code, err
+}
+
+func (p *Pool) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) (result []byte, err error) {
+	err = p.call(ctx, func(e *poolEndpoint) (err error) {
+		result, err = e.backend.CallContract(ctx, call, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+func (p *Pool) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (logs []types.Log, err error) {
+	err = p.call(ctx, func(e *poolEndpoint) (err error) {
+		logs, err = e.backend.FilterLogs(ctx, q)
+		return err
+	})
+	return logs, err
+}
+
+func (p *Pool) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) (value []byte, err error) {
+	err = p.call(ctx, func(e *poolEndpoint) (err error) {
+		value, err = e.backend.StorageAt(ctx, account, key, blockNumber)
+		return err
+	})
+	return value, err
+}
+
+// RPC returns a raw JSON-RPC caller which distributes the calls across the pool, or nil if not all endpoints support it
+func (p *Pool) RPC() RPCCaller {
+	for _, e := range p.endpoints {
+		if e.rpc == nil {
+			return nil
+		}
+	}
+	return poolRPC{p}
+}
+
+type poolRPC struct {
+	pool *Pool
+}
+
+func (r poolRPC) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	return r.pool.call(ctx, func(e *poolEndpoint) error {
+		return e.rpc.CallContext(ctx, result, method, args...)
+	})
+}
+
+func (r poolRPC) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	return r.pool.call(ctx, func(e *poolEndpoint) error {
+		return e.rpc.BatchCallContext(ctx, b)
+	})
+}
@@ -0,0 +1,131 @@
+package ethrpc_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+var errUnavailable = rpc.HTTPError{StatusCode: 503, Status: "503 Service Unavailable"} // transient
+
+// fakeBackend answers HeaderByNumber with err after delay, all other methods are not implemented
+type fakeBackend struct {
+	ethrpc.Backend
+
+	lock  sync.Mutex
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (b *fakeBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.calls++
+	time.Sleep(b.delay)
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &types.Header{Number: number}, nil
+}
+
+func (b *fakeBackend) set(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.err = err
+}
+
+func (b *fakeBackend) numCalls() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.calls
+}
+
+func callPool(t *testing.T, pool *ethrpc.Pool, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := pool.HeaderByNumber(context.Background(), big.NewInt(1)); err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	failing, healthy := &fakeBackend{err: errUnavailable}, &fakeBackend{}
+	pool := ethrpc.NewPool(ethrpc.PoolOptions{MaxFailures: 2, EjectDuration: time.Hour}, failing, healthy)
+
+	// Calls failing with transient errors are retried on the other endpoint, and the failing one is ejected after 2
+	// failures
+	callPool(t, pool, 10)
+	if failing.numCalls() != 2 || healthy.numCalls() != 10 {
+		t.Errorf("%d calls of the failing and %d of the healthy endpoint, expected 2 and 10", failing.numCalls(), healthy.numCalls())
+	}
+
+	// Other errors are returned without trying the next endpoint
+	notFound, other := &fakeBackend{err: ethereum.NotFound}, &fakeBackend{err: ethereum.NotFound}
+	pool = ethrpc.NewPool(ethrpc.PoolOptions{}, notFound, other)
+	if _, err := pool.HeaderByNumber(context.Background(), nil); !errors.Is(err, ethereum.NotFound) || notFound.numCalls()+other.numCalls() != 1 {
+		t.Errorf("got error %v after %d calls, expected NotFound after one call", err, notFound.numCalls()+other.numCalls())
+	}
+
+	if _, err := ethrpc.NewPool(ethrpc.PoolOptions{}).HeaderByNumber(context.Background(), nil); err != ethrpc.ErrNoEndpoints {
+		t.Errorf("got error %v from an empty pool, expected ErrNoEndpoints", err)
+	}
+}
+
+func TestPoolMaxLatency(t *testing.T) {
+	slow, fast := &fakeBackend{delay: 20 * time.Millisecond}, &fakeBackend{}
+	pool := ethrpc.NewPool(ethrpc.PoolOptions{MaxFailures: 1, EjectDuration: time.Hour, MaxLatency: 10 * time.Millisecond}, slow, fast)
+
+	// The slow endpoint's answer is still used, but it's ejected afterwards
+	callPool(t, pool, 6)
+	if slow.numCalls() != 1 || fast.numCalls() != 5 {
+		t.Errorf("%d calls of the slow and %d of the fast endpoint, expected 1 and 5", slow.numCalls(), fast.numCalls())
+	}
+}
+
+func TestPoolReadmission(t *testing.T) {
+	flaky, healthy := &fakeBackend{err: errUnavailable}, &fakeBackend{}
+	pool := ethrpc.NewPool(ethrpc.PoolOptions{MaxFailures: 1, EjectDuration: 50 * time.Millisecond}, flaky, healthy)
+
+	callPool(t, pool, 4)
+	if flaky.numCalls() != 1 {
+		t.Fatalf("%d calls of the flaky endpoint, expected 1 before it was ejected", flaky.numCalls())
+	}
+
+	// After EjectDuration, the recovered endpoint gets its share of the calls again
+	flaky.set(nil)
+	time.Sleep(60 * time.Millisecond)
+	callPool(t, pool, 4)
+	if flaky.numCalls() != 3 {
+		t.Errorf("%d calls of the flaky endpoint, expected 3 after re-admission", flaky.numCalls())
+	}
+}
+
+func TestPoolAllEjected(t *testing.T) {
+	a, b := &fakeBackend{err: errUnavailable}, &fakeBackend{err: errUnavailable}
+	pool := ethrpc.NewPool(ethrpc.PoolOptions{MaxFailures: 1, EjectDuration: time.Hour}, a, b)
+
+	// Both endpoints are tried, and both are ejected
+	if _, err := pool.HeaderByNumber(context.Background(), nil); !errors.As(err, new(rpc.HTTPError)) {
+		t.Fatalf("got error %v, expected the HTTP error", err)
+	}
+	if a.numCalls() != 1 || b.numCalls() != 1 {
+		t.Fatalf("%d and %d calls, expected one per endpoint", a.numCalls(), b.numCalls())
+	}
+
+	// With all endpoints ejected, they are used anyway
+	b.set(nil)
+	callPool(t, pool, 4)
+	if b.numCalls() != 5 {
+		t.Errorf("%d calls of the recovered endpoint, expected 5", b.numCalls())
+	}
+}
@@ -0,0 +1,196 @@
+package ethrpc
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// RateLimiter is a token bucket which allows rate requests per second on average, with bursts of up to burst
+// requests. Methods can be weighted, for providers which count expensive methods as multiple requests.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	lock    sync.Mutex
+	tokens  float64
+	last    time.Time
+	weights map[string]float64
+}
+
+// NewRateLimiter creates a rate limiter which starts with a full bucket. The rate must be positive.
+func NewRateLimiter(rate float64, burst int) (*RateLimiter, error) {
+	if !(rate > 0) { // also NaN
+		return nil, fmt.Errorf("invalid rate limit %v, must be a positive number of requests per second", rate)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		last:    time.Now(),
+		weights: make(map[string]float64),
+	}, nil
+}
+
+// SetMethodWeight sets how many requests a call of the JSON-RPC method counts as (default: 1)
+func (l *RateLimiter) SetMethodWeight(method string, weight float64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.weights[method] = weight
+}
+
+// MethodWeight returns how many requests a call of the JSON-RPC method counts as
+func (l *RateLimiter) MethodWeight(method string) float64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if weight, found := l.weights[method]; found {
+		return weight
+	}
+	return 1
+}
+
+// Wait blocks until the requests with the given total weight are allowed, or ctx is done
+func (l *RateLimiter) Wait(ctx context.Context, weight float64) error {
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	// Take the tokens right away (the bucket may go negative), and wait until they would have been available
+	l.tokens -= weight
+	if l.tokens >= 0 {
+		l.lock.Unlock()
+		return nil
+	}
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.lock.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.lock.Lock()
+		l.tokens += weight // give back the unused tokens
+		l.lock.Unlock()
+		return ctx.Err()
+	}
+}
+
+// WaitMethod blocks until a call of the JSON-RPC method is allowed, or ctx is done
+func (l *RateLimiter) WaitMethod(ctx context.Context, method string) error {
+	return l.Wait(ctx, l.MethodWeight(method))
+}
+
+// RateLimitedBackend is a Backend which waits for the rate limiter before every call. If the wrapped backend can make
+// raw JSON-RPC calls, these are rate limited too (every element of a batch request counts).
+type RateLimitedBackend struct {
+	backend Backend
+	limiter *RateLimiter
+}
+
+func NewRateLimitedBackend(backend Backend, limiter *RateLimiter) *RateLimitedBackend {
+	return &RateLimitedBackend{backend: backend, limiter: limiter}
+}
+
+func (b *RateLimitedBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	if err := b.limiter.WaitMethod(ctx, "eth_getBlockByNumber"); err != nil {
+		return nil, err
+	}
+	return b.backend.HeaderByNumber(ctx, number)
+}
+
+func (b *RateLimitedBackend) BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error) {
+	if err := b.limiter.WaitMethod(ctx, "eth_getBlockByNumber"); err != nil {
+		return nil, err
+	}
+	return b.backend.BlockByNumber(ctx, number)
+}
+
+func (b *RateLimitedBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error) {
+	if err := b.limiter.WaitMethod(ctx, "eth_getTransactionReceipt"); err != nil {
+		return nil, err
+	}
+	return b.backend.TransactionReceipt(ctx, txHash)
+}
+
+func (b *RateLimitedBackend) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	if err := b.limiter.WaitMethod(ctx, "eth_subscribe"); err != nil {
+		return nil, err
+	}
+	return b.backend.SubscribeNewHead(ctx, ch)
+}
+
+func (b *RateLimitedBackend) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error) {
+	if err := b.limiter.WaitMethod(ctx, "eth_getCode"); err != nil {
+		return nil, err
+	}
+	return b.backend.CodeAt(ctx, account, blockNumber)
+}
+
+func (b *RateLimitedBackend) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if err := b.limiter.WaitMethod(ctx, "eth_call"); err != nil {
+		return nil, err
+	}
+	return b.backend.CallContract(ctx, call, blockNumber)
+}
+
+func (b *RateLimitedBackend) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	if err := b.limiter.WaitMethod(ctx, "eth_getLogs"); err != nil {
+		return nil, err
+	}
+	return b.backend.FilterLogs(ctx, q)
+}
+
+func (b *RateLimitedBackend) StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error) {
+	if err := b.limiter.WaitMethod(ctx, "eth_getStorageAt"); err != nil {
+		return nil, err
+	}
+	return b.backend.StorageAt(ctx, account, key, blockNumber)
+}
+
+// RPC returns the rate limited raw JSON-RPC connection of the wrapped backend, or nil if it doesn't have one
+func (b *RateLimitedBackend) RPC() RPCCaller {
+	caller := GetRPCCaller(b.backend)
+	if caller == nil {
+		return nil
+	}
+	return rateLimitedRPC{caller: caller, limiter: b.limiter}
+}
+
+type rateLimitedRPC struct {
+	caller  RPCCaller
+	limiter *RateLimiter
+}
+
+func (r rateLimitedRPC) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if err := r.limiter.WaitMethod(ctx, method); err != nil {
+		return err
+	}
+	return r.caller.CallContext(ctx, result, method, args...)
+}
+
+func (r rateLimitedRPC) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	weight := 0.0
+	for _, elem := range b {
+		weight += r.limiter.MethodWeight(elem.Method)
+	}
+	if err := r.limiter.Wait(ctx, weight); err != nil {
+		return err
+	}
+	return r.caller.BatchCallContext(ctx, b)
+}
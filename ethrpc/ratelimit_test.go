@@ -0,0 +1,101 @@
+package ethrpc_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+func newRateLimiter(t *testing.T, rate float64, burst int) *ethrpc.RateLimiter {
+	t.Helper()
+	limiter, err := ethrpc.NewRateLimiter(rate, burst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return limiter
+}
+
+// waitDuration returns how long Wait blocked
+func waitDuration(t *testing.T, limiter *ethrpc.RateLimiter, weight float64) time.Duration {
+	t.Helper()
+	start := time.Now()
+	if err := limiter.Wait(context.Background(), weight); err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(start)
+}
+
+func TestNewRateLimiterInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		if _, err := ethrpc.NewRateLimiter(rate, 1); err == nil {
+			t.Errorf("rate %v: expected an error", rate)
+		}
+	}
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	limiter := newRateLimiter(t, 20, 3) // a token every 50ms
+
+	// The full bucket allows a burst
+	for i := 0; i < 3; i++ {
+		if d := waitDuration(t, limiter, 1); d > 20*time.Millisecond {
+			t.Errorf("request %d of the burst waited %v", i, d)
+		}
+	}
+
+	// Then the requests are limited to the rate
+	if d := waitDuration(t, limiter, 1); d < 30*time.Millisecond || d > 150*time.Millisecond {
+		t.Errorf("request after the burst waited %v, expected about 50ms", d)
+	}
+
+	// The bucket refills over time, but not above the burst size
+	time.Sleep(300 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if d := waitDuration(t, limiter, 1); d > 20*time.Millisecond {
+			t.Errorf("request %d after the refill waited %v", i, d)
+		}
+	}
+	if d := waitDuration(t, limiter, 1); d < 30*time.Millisecond {
+		t.Errorf("request after the second burst waited %v, expected about 50ms", d)
+	}
+}
+
+func TestRateLimiterMethodWeight(t *testing.T) {
+	limiter := newRateLimiter(t, 20, 5)
+	limiter.SetMethodWeight("eth_getLogs", 5)
+	if limiter.MethodWeight("eth_getLogs") != 5 || limiter.MethodWeight("eth_call") != 1 {
+		t.Fatalf("unexpected weights %v and %v", limiter.MethodWeight("eth_getLogs"), limiter.MethodWeight("eth_call"))
+	}
+
+	// eth_getLogs takes the whole bucket, the next call waits for a token
+	start := time.Now()
+	if err := limiter.WaitMethod(context.Background(), "eth_getLogs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.WaitMethod(context.Background(), "eth_call"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond || d > 150*time.Millisecond {
+		t.Errorf("waited %v, expected about 50ms", d)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter := newRateLimiter(t, 10, 1) // a token every 100ms
+	waitDuration(t, limiter, 1)
+
+	// A cancelled wait gives its tokens back
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx, 10); err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, expected DeadlineExceeded", err)
+	}
+
+	// So the next request only waits for the one token taken above (without the give-back it would take over a second)
+	if d := waitDuration(t, limiter, 1); d > 150*time.Millisecond {
+		t.Errorf("waited %v after the cancelled request, expected less than 100ms", d)
+	}
+}
@@ -0,0 +1,247 @@
+// Decode event logs of receipts and eth_getLogs results, using a registry of contract ABIs
+package eventdecoder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/abijson"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+// ErrUnknownEvent is returned (wrapped) for logs whose topic0 doesn't match any registered event
+var ErrUnknownEvent = errors.New("unknown event signature")
+
+// DecodedEvent is a log decoded with the ABI of its event
+type DecodedEvent struct {
+	Name      string                 // eg. "Swap"
+	Signature string                 // eg. "Swap(address,uint256,uint256,uint256,uint256,address)"
+	Args      map[string]interface{} // all arguments (indexed and non-indexed) by name, unnamed ones are "arg0", "arg1", ...
+	Event     abi.Event
+	Log       *types.Log
+}
+
+func (e *DecodedEvent) String() string {
+	args := make([]string, len(e.Event.Inputs))
+	for i, input := range e.Event.Inputs {
+		args[i] = fmt.Sprintf("%s=%v", input.Name, e.Args[input.Name])
+	}
+	return fmt.Sprintf("%s(%s)", e.Name, strings.Join(args, ", "))
+}
+
+// Registry holds the events of the loaded ABIs, by topic0. Several events can have the same topic0 if the indexed
+// parameters differ (eg. the ERC20 and ERC721 Transfer events), the decoder picks the one matching the log.
+type Registry struct {
+	lock   sync.RWMutex
+	events map[common.Hash][]abi.Event
+}
+
+func NewRegistry() *Registry {
+	return &Registry{events: make(map[common.Hash][]abi.Event)}
+}
+
+// AddEvent registers a single event. Anonymous events are ignored since they can't be matched by topic0.
+func (r *Registry) AddEvent(event abi.Event) {
+	if event.Anonymous {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, existing := range r.events[event.ID] {
+		if sameIndexedInputs(existing, event) {
+			return // already known
+		}
+	}
+	r.events[event.ID] = append(r.events[event.ID], event)
+}
+
+// AddABI registers all events of an ABI
+func (r *Registry) AddABI(contractAbi abi.ABI) {
+	for _, event := range contractAbi.Events {
+		r.AddEvent(event)
+	}
+}
+
+// AddABIJSON registers all events of an ABI in JSON format (see ParseABIJSON)
+func (r *Registry) AddABIJSON(data []byte) error {
+	contractAbi, err := ParseABIJSON(data)
+	if err != nil {
+		return err
+	}
+	r.AddABI(contractAbi)
+	return nil
+}
+
+// AddABIFile registers all events of an ABI JSON file (see AddABIJSON)
+func (r *Registry) AddABIFile(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := r.AddABIJSON(data); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// AddABIDir registers the events of all *.json files in a directory
+func (r *Registry) AddABIDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, filename := range files {
+		if err := r.AddABIFile(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseABIJSON parses an ABI in JSON format, plain or inside a compiler artifact (see abijson.Parse)
+func ParseABIJSON(data []byte) (abi.ABI, error) {
+	return abijson.Parse(data)
+}
+
+// Events returns the registered events with this topic0
+func (r *Registry) Events(topic0 common.Hash) []abi.Event {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.events[topic0]
+}
+
+// findEvent returns the registered event matching the topics and data of the log
+func (r *Registry) findEvent(log *types.Log) (event abi.Event, err error) {
+	if len(log.Topics) == 0 {
+		return event, fmt.Errorf("%w: log without topics", ErrUnknownEvent)
+	}
+
+	candidates := r.Events(log.Topics[0])
+	if len(candidates) == 0 {
+		return event, fmt.Errorf("%w: %s", ErrUnknownEvent, log.Topics[0].Hex())
+	}
+	for _, candidate := range candidates {
+		if numIndexed(candidate) == len(log.Topics)-1 {
+			return candidate, nil
+		}
+	}
+	return event, fmt.Errorf("%w: %s with %d indexed arguments", ErrUnknownEvent, candidates[0].Sig, len(log.Topics)-1)
+}
+
+// Decode decodes a log into its arguments. For eth_getLogs results ([]types.Log), pass a pointer to the element.
+func (r *Registry) Decode(log *types.Log) (*DecodedEvent, error) {
+	event, err := r.findEvent(log)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make(map[string]interface{})
+	if err := event.Inputs.UnpackIntoMap(args, log.Data); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", event.Sig, err)
+	}
+	if err := abi.ParseTopicsIntoMap(args, indexedInputs(event), log.Topics[1:]); err != nil {
+		return nil, fmt.Errorf("decoding %s: %v", event.Sig, err)
+	}
+
+	return &DecodedEvent{Name: event.Name, Signature: event.Sig, Args: args, Event: event, Log: log}, nil
+}
+
+// DecodeInto decodes a log into a struct, whose fields are matched to the arguments by name (like abigen bindings, eg.
+// argument "amount0In" into field Amount0In)
+func (r *Registry) DecodeInto(log *types.Log, out interface{}) error {
+	event, err := r.findEvent(log)
+	if err != nil {
+		return err
+	}
+
+	if len(log.Data) > 0 {
+		values, err := event.Inputs.Unpack(log.Data)
+		if err != nil {
+			return fmt.Errorf("decoding %s: %v", event.Sig, err)
+		}
+		if err := event.Inputs.Copy(out, values); err != nil {
+			return fmt.Errorf("decoding %s: %v", event.Sig, err)
+		}
+	}
+	if err := abi.ParseTopics(out, indexedInputs(event), log.Topics[1:]); err != nil {
+		return fmt.Errorf("decoding %s: %v", event.Sig, err)
+	}
+	return nil
+}
+
+// DecodeLogs decodes all logs it can, and returns the others (unknown signatures or invalid data) separately
+func (r *Registry) DecodeLogs(logs []*types.Log) (events []*DecodedEvent, unknown []*types.Log) {
+	for _, log := range logs {
+		event, err := r.Decode(log)
+		if err != nil {
+			unknown = append(unknown, log)
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, unknown
+}
+
+// DecodeFilterLogs is DecodeLogs for the results of eth_getLogs (eg. ethclient's FilterLogs or utils.GetLogs). The
+// decoded events and unknown logs point into the logs slice.
+func (r *Registry) DecodeFilterLogs(logs []types.Log) (events []*DecodedEvent, unknown []*types.Log) {
+	for i := range logs {
+		event, err := r.Decode(&logs[i])
+		if err != nil {
+			unknown = append(unknown, &logs[i])
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, unknown
+}
+
+// DecodeReceipt decodes the logs of a transaction receipt (see DecodeLogs)
+func (r *Registry) DecodeReceipt(receipt *types.Receipt) (events []*DecodedEvent, unknown []*types.Log) {
+	return r.DecodeLogs(receipt.Logs)
+}
+
+// DecodeBlock decodes the logs of all transactions of a block, in transaction and log order (see DecodeLogs)
+func (r *Registry) DecodeBlock(b *blockswithtx.BlockWithTxReceipts) (events []*DecodedEvent, unknown []*types.Log) {
+	for _, tx := range b.Block.Transactions() {
+		if receipt := b.TxReceipts[tx.Hash()]; receipt != nil {
+			txEvents, txUnknown := r.DecodeReceipt(receipt)
+			events = append(events, txEvents...)
+			unknown = append(unknown, txUnknown...)
+		}
+	}
+	return events, unknown
+}
+
+func indexedInputs(event abi.Event) (indexed abi.Arguments) {
+	for _, input := range event.Inputs {
+		if input.Indexed {
+			indexed = append(indexed, input)
+		}
+	}
+	return indexed
+}
+
+func numIndexed(event abi.Event) int {
+	return len(indexedInputs(event))
+}
+
+func sameIndexedInputs(a, b abi.Event) bool {
+	if len(a.Inputs) != len(b.Inputs) {
+		return false
+	}
+	for i := range a.Inputs {
+		if a.Inputs[i].Indexed != b.Inputs[i].Indexed {
+			return false
+		}
+	}
+	return true
+}
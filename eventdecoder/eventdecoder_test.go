@@ -0,0 +1,201 @@
+package eventdecoder_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/eth-go-bindings/erc20"
+	"github.com/metachris/go-ethutils/blockswithtx"
+	"github.com/metachris/go-ethutils/eventdecoder"
+	"github.com/metachris/go-ethutils/rpctest"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+var (
+	alice = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	bob   = common.HexToAddress("0x2000000000000000000000000000000000000002")
+
+	topicSwap     = crypto.Keccak256Hash([]byte("Swap(address,uint256,uint256,uint256,uint256,address)"))
+	topicSync     = crypto.Keccak256Hash([]byte("Sync(uint112,uint112)"))
+	topicTransfer = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+)
+
+func word(n int64) []byte {
+	return common.LeftPadBytes(big.NewInt(n).Bytes(), 32)
+}
+
+func addressTopic(a common.Address) common.Hash {
+	return common.BytesToHash(a.Bytes())
+}
+
+func concat(parts ...[]byte) (b []byte) {
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func newRegistry(t *testing.T) *eventdecoder.Registry {
+	r := eventdecoder.NewRegistry()
+	if err := r.AddABIDir("testdata"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AddABIJSON([]byte(erc20.Erc20ABI)); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestDecode(t *testing.T) {
+	r := newRegistry(t)
+
+	swap := &types.Log{Topics: []common.Hash{topicSwap, addressTopic(alice), addressTopic(bob)}, Data: concat(word(100), word(0), word(0), word(250))}
+	event, err := r.Decode(swap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Name != "Swap" || event.Args["sender"] != alice || event.Args["to"] != bob || event.Args["amount0In"].(*big.Int).Int64() != 100 || event.Args["amount1Out"].(*big.Int).Int64() != 250 {
+		t.Errorf("unexpected swap %s", event)
+	}
+
+	var s struct {
+		Sender     common.Address
+		Amount0In  *big.Int
+		Amount1In  *big.Int
+		Amount0Out *big.Int
+		Amount1Out *big.Int
+		To         common.Address
+	}
+	if err := r.DecodeInto(swap, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Sender != alice || s.To != bob || s.Amount0In.Int64() != 100 || s.Amount1Out.Int64() != 250 {
+		t.Errorf("unexpected swap struct %+v", s)
+	}
+
+	sync := &types.Log{Topics: []common.Hash{topicSync}, Data: concat(word(1000), word(2000))}
+	event, err = r.Decode(sync)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Name != "Sync" || event.Args["reserve0"].(*big.Int).Int64() != 1000 || event.Args["reserve1"].(*big.Int).Int64() != 2000 {
+		t.Errorf("unexpected sync %s", event)
+	}
+
+	// ERC20 and ERC721 Transfer events share topic0, the number of topics decides
+	erc721Transfer := &types.Log{Topics: []common.Hash{topicTransfer, addressTopic(alice), addressTopic(bob), common.BigToHash(big.NewInt(42))}}
+	event, err = r.Decode(erc721Transfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Args["tokenId"].(*big.Int).Int64() != 42 {
+		t.Errorf("unexpected erc721 transfer %s", event)
+	}
+
+	unknown := &types.Log{Topics: []common.Hash{crypto.Keccak256Hash([]byte("Mint(address,uint256,uint256)"))}, Data: concat(word(1), word(2))}
+	if _, err := r.Decode(unknown); !errors.Is(err, eventdecoder.ErrUnknownEvent) {
+		t.Errorf("expected ErrUnknownEvent, got %v", err)
+	}
+
+	events, unknownLogs := r.DecodeLogs([]*types.Log{swap, unknown, sync})
+	if len(events) != 2 || len(unknownLogs) != 1 || unknownLogs[0] != unknown {
+		t.Errorf("got %d events and %d unknown logs, expected 2 and 1", len(events), len(unknownLogs))
+	}
+
+	// eth_getLogs results are values
+	filterLogs := []types.Log{*swap, *unknown, *sync}
+	events, unknownLogs = r.DecodeFilterLogs(filterLogs)
+	if len(events) != 2 || len(unknownLogs) != 1 || unknownLogs[0] != &filterLogs[1] {
+		t.Fatalf("got %d events and %d unknown logs, expected 2 and 1", len(events), len(unknownLogs))
+	}
+	if events[0].Name != "Swap" || events[0].Log != &filterLogs[0] || events[1].Name != "Sync" || events[1].Log != &filterLogs[2] {
+		t.Errorf("unexpected events %s and %s", events[0], events[1])
+	}
+}
+
+func TestDecodeBlock(t *testing.T) {
+	fixtures, err := rpctest.LoadFixtures("../rpctest/testdata/chain.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	defer server.Close()
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Block 12323931 has 3 transactions, the second one is a transfer of 12323931000 tokens
+	b, err := blockswithtx.GetBlockWithTxReceipts(client, 12323931)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, unknown := newRegistry(t).DecodeBlock(b)
+	if len(events) != 1 || len(unknown) != 0 {
+		t.Fatalf("got %d events and %d unknown logs, expected 1 and 0", len(events), len(unknown))
+	}
+	if events[0].Signature != "Transfer(address,address,uint256)" || events[0].Args["value"].(*big.Int).Int64() != 12323931000 {
+		t.Errorf("unexpected event %s", events[0])
+	}
+}
+
+// receiptLogFilterer answers eth_getLogs with the logs of a block's receipts
+type receiptLogFilterer struct {
+	block *blockswithtx.BlockWithTxReceipts
+}
+
+func (f receiptLogFilterer) FilterLogs(ctx context.Context, q ethereum.FilterQuery) (logs []types.Log, err error) {
+	if q.FromBlock.Cmp(f.block.Block.Number()) > 0 || q.ToBlock.Cmp(f.block.Block.Number()) < 0 {
+		return nil, nil
+	}
+	for _, receipt := range f.block.TxReceipts {
+		for _, log := range receipt.Logs {
+			logs = append(logs, *log)
+		}
+	}
+	return logs, nil
+}
+
+func TestDecodeFilterLogs(t *testing.T) {
+	fixtures, err := rpctest.LoadFixtures("../rpctest/testdata/chain.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	defer server.Close()
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	b, err := blockswithtx.GetBlockWithTxReceipts(client, 12323931)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logChan := make(chan types.Log, 100)
+	if err := utils.GetLogs(logChan, receiptLogFilterer{b}, ethereum.FilterQuery{}, 12323925, 12323935, 2); err != nil {
+		t.Fatal(err)
+	}
+	close(logChan)
+	var logs []types.Log
+	for log := range logChan {
+		logs = append(logs, log)
+	}
+
+	events, unknown := newRegistry(t).DecodeFilterLogs(logs)
+	if len(events) != 1 || len(unknown) != 0 {
+		t.Fatalf("got %d events and %d unknown logs, expected 1 and 0", len(events), len(unknown))
+	}
+	if events[0].Args["value"].(*big.Int).Int64() != 12323931000 {
+		t.Errorf("unexpected event %s", events[0])
+	}
+}
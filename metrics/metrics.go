@@ -0,0 +1,283 @@
+// Metrics and progress reporting for the block pipelines and RPC calls
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds (in seconds) of the latency histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in cumulative buckets, like a Prometheus histogram
+type Histogram struct {
+	Buckets []float64 // upper bounds
+	Counts  []uint64  // Counts[i] is the number of observations <= Buckets[i]
+	Count   uint64
+	Sum     float64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{Buckets: buckets, Counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) observe(v float64) {
+	for i, upperBound := range h.Buckets {
+		if v <= upperBound {
+			h.Counts[i]++
+		}
+	}
+	h.Count++
+	h.Sum += v
+}
+
+func (h *Histogram) copy() *Histogram {
+	c := *h
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return &c
+}
+
+// RPCStats are the metrics of a JSON-RPC method
+type RPCStats struct {
+	Calls    uint64
+	Errors   uint64
+	Duration *Histogram // in seconds
+}
+
+// Stats is a snapshot of the collected metrics
+type Stats struct {
+	Elapsed time.Duration
+
+	StartBlock    int64
+	EndBlock      int64
+	BlocksFetched uint64 // including failed fetches
+	BlocksFailed  uint64
+	BlocksSkipped uint64 // already done according to the checkpoint
+	BlocksEmitted uint64
+	Items         uint64 // eg. transactions
+	FetchDuration *Histogram
+
+	Workers     int
+	BusyWorkers int
+	QueueDepth  int
+
+	RPC map[string]RPCStats // by method
+}
+
+// BlocksPerSecond is the rate of blocks handed to the consumer
+func (s Stats) BlocksPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.BlocksEmitted) / s.Elapsed.Seconds()
+}
+
+// ItemsPerSecond is the rate of items (eg. transactions) handed to the consumer
+func (s Stats) ItemsPerSecond() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Items) / s.Elapsed.Seconds()
+}
+
+// Remaining is the number of heights in the range which are not processed yet
+func (s Stats) Remaining() int64 {
+	remaining := s.EndBlock - s.StartBlock + 1 - int64(s.BlocksEmitted+s.BlocksFailed+s.BlocksSkipped)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// Progress is the processed part of the range (0 to 1)
+func (s Stats) Progress() float64 {
+	total := s.EndBlock - s.StartBlock + 1
+	if total <= 0 {
+		return 1
+	}
+	return 1 - float64(s.Remaining())/float64(total)
+}
+
+// ETA estimates the time until the range is done from the rate so far (0 if unknown)
+func (s Stats) ETA() time.Duration {
+	processed := s.BlocksEmitted + s.BlocksFailed
+	if processed == 0 || s.Elapsed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(s.Remaining()) / float64(processed) * float64(s.Elapsed))
+}
+
+// Utilization is the share of busy workers (0 to 1)
+func (s Stats) Utilization() float64 {
+	if s.Workers == 0 {
+		return 0
+	}
+	return float64(s.BusyWorkers) / float64(s.Workers)
+}
+
+func (s Stats) String() string {
+	var rpcCalls, rpcErrors uint64
+	for _, method := range s.RPC {
+		rpcCalls += method.Calls
+		rpcErrors += method.Errors
+	}
+
+	return fmt.Sprintf("%d/%d blocks (%.1f%%), %.1f blocks/sec, %.1f tx/sec, %d failed, %d rpc calls (%d errors), workers %d/%d, queue %d, eta %s",
+		s.EndBlock-s.StartBlock+1-s.Remaining(), s.EndBlock-s.StartBlock+1, s.Progress()*100, s.BlocksPerSecond(), s.ItemsPerSecond(),
+		s.BlocksFailed, rpcCalls, rpcErrors, s.BusyWorkers, s.Workers, s.QueueDepth, s.ETA().Round(time.Second))
+}
+
+// Collector aggregates the metrics of a pipeline run and the RPC calls it makes. It implements
+// utils.PipelineObserver (set it as PipelineOptions.Observer) and ethrpc.CallObserver (wrap the client with
+// ethrpc.NewInstrumentedBackend). Use one collector per pipeline.
+//
+// The metrics are available as Stats snapshot, as periodic progress report (Report), and in the Prometheus text
+// format (ServeHTTP / WritePrometheus).
+type Collector struct {
+	// Namespace is the prefix of the Prometheus metric names (default: "ethutils")
+	Namespace string
+
+	buckets []float64
+
+	lock          sync.Mutex
+	started       time.Time
+	startBlock    int64
+	endBlock      int64
+	blocksFetched uint64
+	blocksFailed  uint64
+	blocksSkipped uint64
+	blocksEmitted uint64
+	items         uint64
+	fetchDuration *Histogram
+	workers       int
+	busyWorkers   int
+	queueDepth    int
+	rpc           map[string]*RPCStats
+}
+
+func NewCollector() *Collector {
+	return &Collector{
+		buckets:       DefaultBuckets,
+		fetchDuration: newHistogram(DefaultBuckets),
+		rpc:           make(map[string]*RPCStats),
+	}
+}
+
+// RangeUpdated implements utils.PipelineObserver. The elapsed time starts with the first call.
+func (c *Collector) RangeUpdated(startBlock int64, endBlock int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.started.IsZero() {
+		c.started = time.Now()
+	}
+	c.startBlock = startBlock
+	c.endBlock = endBlock
+}
+
+func (c *Collector) BlockFetched(height int64, duration time.Duration, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blocksFetched++
+	if err != nil {
+		c.blocksFailed++
+	}
+	c.fetchDuration.observe(duration.Seconds())
+}
+
+func (c *Collector) BlockSkipped(height int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blocksSkipped++
+}
+
+func (c *Collector) BlockEmitted(height int64, items int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blocksEmitted++
+	c.items += uint64(items)
+}
+
+func (c *Collector) WorkersBusy(busy int, total int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.busyWorkers = busy
+	c.workers = total
+}
+
+func (c *Collector) QueueDepth(depth int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.queueDepth = depth
+}
+
+// ObserveCall implements ethrpc.CallObserver
+func (c *Collector) ObserveCall(method string, duration time.Duration, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stats, found := c.rpc[method]
+	if !found {
+		stats = &RPCStats{Duration: newHistogram(c.buckets)}
+		c.rpc[method] = stats
+	}
+	stats.Calls++
+	if err != nil {
+		stats.Errors++
+	}
+	stats.Duration.observe(duration.Seconds())
+}
+
+// Stats returns a snapshot of the metrics
+func (c *Collector) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s := Stats{
+		StartBlock:    c.startBlock,
+		EndBlock:      c.endBlock,
+		BlocksFetched: c.blocksFetched,
+		BlocksFailed:  c.blocksFailed,
+		BlocksSkipped: c.blocksSkipped,
+		BlocksEmitted: c.blocksEmitted,
+		Items:         c.items,
+		FetchDuration: c.fetchDuration.copy(),
+		Workers:       c.workers,
+		BusyWorkers:   c.busyWorkers,
+		QueueDepth:    c.queueDepth,
+		RPC:           make(map[string]RPCStats, len(c.rpc)),
+	}
+	if !c.started.IsZero() {
+		s.Elapsed = time.Since(c.started)
+	}
+	for method, stats := range c.rpc {
+		s.RPC[method] = RPCStats{Calls: stats.Calls, Errors: stats.Errors, Duration: stats.Duration.copy()}
+	}
+	return s
+}
+
+// Report writes a progress line to w every interval, until ctx is done
+func (c *Collector) Report(ctx context.Context, w io.Writer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fmt.Fprintln(w, c.Stats())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func sortedMethods(rpc map[string]RPCStats) []string {
+	methods := make([]string, 0, len(rpc))
+	for method := range rpc {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
@@ -0,0 +1,78 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/metachris/go-ethutils/blockswithtx"
+	"github.com/metachris/go-ethutils/ethrpc"
+	"github.com/metachris/go-ethutils/metrics"
+	"github.com/metachris/go-ethutils/rpctest"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+func TestCollector(t *testing.T) {
+	fixtures, err := rpctest.LoadFixtures("../rpctest/testdata/chain.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	defer server.Close()
+	client, err := ethrpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	collector := metrics.NewCollector()
+	backend := ethrpc.NewInstrumentedBackend(client, collector)
+
+	// Blocks 12323930 to 12323970 have 62 transactions, 12323971 and 12323972 don't exist
+	blockChan := make(chan *blockswithtx.BlockWithTxReceipts, 100)
+	opts := blockswithtx.Options{PipelineOptions: utils.PipelineOptions{Concurrency: 4, Ordered: true, Observer: collector}}
+	err = blockswithtx.GetBlocksWithTxReceiptsWithOptions(context.Background(), backend, blockChan, 12323930, 12323972, opts)
+	if _, ok := err.(*utils.BlockRangeError); !ok {
+		t.Fatalf("expected BlockRangeError, got %v", err)
+	}
+
+	s := collector.Stats()
+	if s.StartBlock != 12323930 || s.EndBlock != 12323972 {
+		t.Errorf("unexpected range %d-%d", s.StartBlock, s.EndBlock)
+	}
+	if s.BlocksFetched != 43 || s.BlocksFailed != 2 || s.BlocksEmitted != 41 || s.Items != 62 {
+		t.Errorf("unexpected block counts: %+v", s)
+	}
+	if s.Remaining() != 0 || s.Progress() != 1 {
+		t.Errorf("expected the range to be done, got %d remaining", s.Remaining())
+	}
+	if s.Workers != 4 || s.BusyWorkers != 0 || s.QueueDepth != 0 {
+		t.Errorf("unexpected workers/queue: %d/%d, %d", s.BusyWorkers, s.Workers, s.QueueDepth)
+	}
+	if rpc := s.RPC["eth_getBlockByNumber"]; rpc.Calls != 43 || rpc.Errors != 2 || rpc.Duration.Count != 43 {
+		t.Errorf("unexpected eth_getBlockByNumber stats %+v", rpc)
+	}
+	if rpc := s.RPC["eth_getBlockReceipts"]; rpc.Calls != 31 || rpc.Errors != 0 {
+		t.Errorf("unexpected eth_getBlockReceipts stats %+v", rpc)
+	}
+
+	// Prometheus text format
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE ethutils_pipeline_blocks_emitted_total counter",
+		"ethutils_pipeline_blocks_emitted_total 41",
+		"ethutils_pipeline_items_emitted_total 62",
+		`ethutils_rpc_requests_total{method="eth_getBlockByNumber"} 43`,
+		`ethutils_rpc_errors_total{method="eth_getBlockByNumber"} 2`,
+		`ethutils_rpc_request_duration_seconds_bucket{method="eth_getBlockReceipts",le="+Inf"} 31`,
+		`ethutils_rpc_request_duration_seconds_count{method="eth_getBlockReceipts"} 31`,
+		"ethutils_pipeline_block_fetch_duration_seconds_count 43",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing line %q in:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ServeHTTP serves the metrics in the Prometheus text format, eg. http.Handle("/metrics", collector)
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.WritePrometheus(w)
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	s := c.Stats()
+	ns := c.Namespace
+	if ns == "" {
+		ns = "ethutils"
+	}
+
+	bw := bufio.NewWriter(w)
+	p := &promWriter{w: bw}
+
+	p.metric(ns+"_pipeline_start_block", "gauge", "First block height of the range", float64(s.StartBlock))
+	p.metric(ns+"_pipeline_end_block", "gauge", "Last block height of the range (grows in follow mode)", float64(s.EndBlock))
+	p.metric(ns+"_pipeline_remaining_blocks", "gauge", "Heights of the range which are not processed yet", float64(s.Remaining()))
+	p.metric(ns+"_pipeline_eta_seconds", "gauge", "Estimated time until the range is processed", s.ETA().Seconds())
+	p.metric(ns+"_pipeline_blocks_fetched_total", "counter", "Fetched block heights, including failed ones", float64(s.BlocksFetched))
+	p.metric(ns+"_pipeline_blocks_failed_total", "counter", "Block heights which could not be fetched", float64(s.BlocksFailed))
+	p.metric(ns+"_pipeline_blocks_skipped_total", "counter", "Block heights skipped because the checkpoint has them as done", float64(s.BlocksSkipped))
+	p.metric(ns+"_pipeline_blocks_emitted_total", "counter", "Blocks handed to the consumer", float64(s.BlocksEmitted))
+	p.metric(ns+"_pipeline_items_emitted_total", "counter", "Items (eg. transactions) in the blocks handed to the consumer", float64(s.Items))
+	p.metric(ns+"_pipeline_workers", "gauge", "Number of workers", float64(s.Workers))
+	p.metric(ns+"_pipeline_workers_busy", "gauge", "Number of workers currently fetching", float64(s.BusyWorkers))
+	p.metric(ns+"_pipeline_queue_depth", "gauge", "Fetched blocks waiting to be handed to the consumer", float64(s.QueueDepth))
+
+	p.header(ns+"_pipeline_block_fetch_duration_seconds", "histogram", "Duration of fetching a block (or batch of blocks)")
+	p.histogram(ns+"_pipeline_block_fetch_duration_seconds", "", s.FetchDuration)
+
+	methods := sortedMethods(s.RPC)
+	p.header(ns+"_rpc_requests_total", "counter", "JSON-RPC calls by method")
+	for _, method := range methods {
+		p.sample(ns+"_rpc_requests_total", methodLabel(method), float64(s.RPC[method].Calls))
+	}
+	p.header(ns+"_rpc_errors_total", "counter", "Failed JSON-RPC calls by method")
+	for _, method := range methods {
+		p.sample(ns+"_rpc_errors_total", methodLabel(method), float64(s.RPC[method].Errors))
+	}
+	p.header(ns+"_rpc_request_duration_seconds", "histogram", "Duration of JSON-RPC calls by method")
+	for _, method := range methods {
+		p.histogram(ns+"_rpc_request_duration_seconds", methodLabel(method), s.RPC[method].Duration)
+	}
+
+	if p.err != nil {
+		return p.err
+	}
+	return bw.Flush()
+}
+
+func methodLabel(method string) string {
+	return "method=" + strconv.Quote(method)
+}
+
+// promWriter writes the text format, and remembers the first error
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func (p *promWriter) header(name string, typ string, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) sample(name string, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+	p.printf("%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (p *promWriter) metric(name string, typ string, help string, value float64) {
+	p.header(name, typ, help)
+	p.sample(name, "", value)
+}
+
+func (p *promWriter) histogram(name string, labels string, h *Histogram) {
+	prefix := ""
+	if labels != "" {
+		prefix = labels + ","
+	}
+	for i, upperBound := range h.Buckets {
+		p.sample(name+"_bucket", prefix+"le="+strconv.Quote(strconv.FormatFloat(upperBound, 'g', -1, 64)), float64(h.Counts[i]))
+	}
+	p.sample(name+"_bucket", prefix+`le="+Inf"`, float64(h.Count))
+	p.sample(name+"_sum", labels, h.Sum)
+	p.sample(name+"_count", labels, float64(h.Count))
+}
@@ -0,0 +1,165 @@
+package rpctest
+
+import (
+	"encoding/json"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// AddBlock adds the responses a node gives for the block and its receipts: eth_getBlockByNumber (with and without
+// transactions), eth_getBlockReceipts and eth_getTransactionReceipt. The block
+// and transaction fields of the receipts and logs are filled in. If latest is true, the block is also returned for
+// the "latest" block number.
+func (f *Fixtures) AddBlock(block *types.Block, receipts types.Receipts, latest bool) error {
+	fillReceipts(block, receipts)
+
+	numberArgs := []interface{}{hexutil.EncodeBig(block.Number())}
+	if latest {
+		numberArgs = append(numberArgs, "latest")
+	}
+	for _, fullTx := range []bool{true, false} {
+		result, err := marshalBlock(block, fullTx)
+		if err != nil {
+			return err
+		}
+		for _, number := range numberArgs {
+			if err := f.Add("eth_getBlockByNumber", []interface{}{number, fullTx}, result); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := f.Add("eth_getBlockReceipts", []interface{}{block.Hash()}, receipts); err != nil {
+		return err
+	}
+	for _, receipt := range receipts {
+		if err := f.Add("eth_getTransactionReceipt", []interface{}{receipt.TxHash}, receipt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddCode adds the eth_getCode response for an address at the latest block
+func (f *Fixtures) AddCode(address common.Address, code []byte) error {
+	return f.Add("eth_getCode", []interface{}{address, "latest"}, hexutil.Bytes(code))
+}
+
+// AddStorage adds the eth_getStorageAt response for a storage slot of an address at the latest block
+func (f *Fixtures) AddStorage(address common.Address, key common.Hash, value common.Hash) error {
+	return f.Add("eth_getStorageAt", []interface{}{address, key, "latest"}, value)
+}
+
+// AddCall adds the eth_call response for a call at the latest block, as sent by ethclient
+func (f *Fixtures) AddCall(msg ethereum.CallMsg, result []byte) error {
+	return f.Add("eth_call", []interface{}{toCallArg(msg), "latest"}, hexutil.Bytes(result))
+}
+
+// AddCallError adds a failing eth_call (eg. "execution reverted") at the latest block
+func (f *Fixtures) AddCallError(msg ethereum.CallMsg, code int, message string) error {
+	return f.AddError("eth_call", []interface{}{toCallArg(msg), "latest"}, code, message)
+}
+
+// toCallArg encodes a call like ethclient does
+func toCallArg(msg ethereum.CallMsg) interface{} {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["data"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	if msg.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	if msg.GasPrice != nil {
+		arg["gasPrice"] = (*hexutil.Big)(msg.GasPrice)
+	}
+	return arg
+}
+
+func fillReceipts(block *types.Block, receipts types.Receipts) {
+	var logIndex uint
+	for i, receipt := range receipts {
+		receipt.BlockHash = block.Hash()
+		receipt.BlockNumber = block.Number()
+		receipt.TransactionIndex = uint(i)
+		if i < len(block.Transactions()) {
+			receipt.TxHash = block.Transactions()[i].Hash()
+		}
+		if receipt.Logs == nil {
+			receipt.Logs = []*types.Log{} // nodes return an empty list, and the receipt JSON decoder requires one
+		}
+		for _, log := range receipt.Logs {
+			log.BlockHash = block.Hash()
+			log.BlockNumber = block.NumberU64()
+			log.TxHash = receipt.TxHash
+			log.TxIndex = uint(i)
+			log.Index = logIndex
+			logIndex++
+		}
+	}
+}
+
+// marshalBlock encodes a block like the eth_getBlockBy* methods of a node
+func marshalBlock(block *types.Block, fullTx bool) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	data, err := json.Marshal(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	fields["size"] = hexutil.Uint64(block.Size())
+
+	uncles := make([]common.Hash, len(block.Uncles()))
+	for i, uncle := range block.Uncles() {
+		uncles[i] = uncle.Hash()
+	}
+	fields["uncles"] = uncles
+
+	txs := make([]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !fullTx {
+			txs[i] = tx.Hash()
+			continue
+		}
+
+		txFields, err := marshalTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		txFields["blockHash"] = block.Hash()
+		txFields["blockNumber"] = (*hexutil.Big)(block.Number())
+		txFields["transactionIndex"] = hexutil.Uint64(i)
+		txs[i] = txFields
+	}
+	fields["transactions"] = txs
+	return fields, nil
+}
+
+func marshalTransaction(tx *types.Transaction) (map[string]interface{}, error) {
+	var fields map[string]interface{}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return nil, err
+	}
+	fields["from"] = from
+	return fields, nil
+}
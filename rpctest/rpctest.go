@@ -0,0 +1,323 @@
+// Record and replay JSON-RPC responses, for testing without an Ethereum node
+package rpctest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Fixture is a recorded JSON-RPC response (either Result or Error) for a method call with certain params
+type Fixture struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Fixtures is a set of recorded responses, looked up by method and params
+type Fixtures struct {
+	lock     sync.RWMutex
+	fixtures map[string]*Fixture
+	methods  map[string]int // number of fixtures per method
+}
+
+func NewFixtures() *Fixtures {
+	return &Fixtures{
+		fixtures: make(map[string]*Fixture),
+		methods:  make(map[string]int),
+	}
+}
+
+// LoadFixtures reads fixtures from a JSON file (see Save)
+func LoadFixtures(filename string) (*Fixtures, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*Fixture
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	f := NewFixtures()
+	for _, fixture := range list {
+		if err := f.add(fixture); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	return f, nil
+}
+
+// Save writes all fixtures to a JSON file, sorted by method and params
+func (f *Fixtures) Save(filename string) error {
+	f.lock.RLock()
+	keys := make([]string, 0, len(f.fixtures))
+	for key := range f.fixtures {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]*Fixture, len(keys))
+	for i, key := range keys {
+		list[i] = f.fixtures[key]
+	}
+	f.lock.RUnlock()
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// Add adds a response for the method called with params. params and result are marshalled to JSON.
+func (f *Fixtures) Add(method string, params []interface{}, result interface{}) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	rawResult, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return f.add(&Fixture{Method: method, Params: rawParams, Result: rawResult})
+}
+
+// AddError adds an error response for the method called with params
+func (f *Fixtures) AddError(method string, params []interface{}, code int, message string) error {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return f.add(&Fixture{Method: method, Params: rawParams, Error: &Error{Code: code, Message: message}})
+}
+
+// RemoveMethod removes all fixtures of a method. The server then answers calls of it with "method not found", like
+// a node that doesn't support it.
+func (f *Fixtures) RemoveMethod(method string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for key, fixture := range f.fixtures {
+		if fixture.Method == method {
+			delete(f.fixtures, key)
+		}
+	}
+	delete(f.methods, method)
+}
+
+// Lookup returns the fixture for the call. hasMethod is true if there are any fixtures for the method.
+func (f *Fixtures) Lookup(method string, params json.RawMessage) (fixture *Fixture, hasMethod bool, err error) {
+	key, err := fixtureKey(method, params)
+	if err != nil {
+		return nil, false, err
+	}
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.fixtures[key], f.methods[method] > 0, nil
+}
+
+func (f *Fixtures) add(fixture *Fixture) error {
+	key, err := fixtureKey(fixture.Method, fixture.Params)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, found := f.fixtures[key]; !found {
+		f.methods[fixture.Method]++
+	}
+	f.fixtures[key] = fixture
+	return nil
+}
+
+// fixtureKey returns the method with the params in canonical JSON (no whitespace, sorted object keys)
+func fixtureKey(method string, params json.RawMessage) (string, error) {
+	if len(bytes.TrimSpace(params)) == 0 || bytes.Equal(bytes.TrimSpace(params), []byte("null")) {
+		return method + "[]", nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("invalid params for %s: %v", method, err)
+	}
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return method + string(canonical), nil
+}
+
+// Server is a JSON-RPC HTTP server which answers calls (single or batch) with the fixtures. Calls of methods without
+// any fixtures fail with "method not found" (-32601), calls with unknown params with -32000.
+//
+// A recording server forwards calls without a fixture to an upstream node instead, and adds the responses to the
+// fixtures, which can then be saved.
+type Server struct {
+	*httptest.Server
+	Fixtures *Fixtures
+
+	upstream *rpc.Client
+
+	lock  sync.Mutex
+	calls map[string]int
+}
+
+func NewServer(fixtures *Fixtures) *Server {
+	s := &Server{Fixtures: fixtures, calls: make(map[string]int)}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// NewRecordingServer creates a server which records the responses of the upstream node (URL of any transport)
+func NewRecordingServer(fixtures *Fixtures, upstreamURL string) (*Server, error) {
+	upstream, err := rpc.Dial(upstreamURL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{Fixtures: fixtures, upstream: upstream, calls: make(map[string]int)}
+	s.Server = httptest.NewServer(s)
+	return s, nil
+}
+
+func (s *Server) Close() {
+	s.Server.Close()
+	if s.upstream != nil {
+		s.upstream.Close()
+	}
+}
+
+// Calls returns how often the method was called
+func (s *Server) Calls(method string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls[method]
+}
+
+type jsonrpcMessage struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resp interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []*jsonrpcMessage
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resps := make([]*jsonrpcMessage, len(reqs))
+		for i, req := range reqs {
+			resps[i] = s.handle(r.Context(), req)
+		}
+		resp = resps
+	} else {
+		var req jsonrpcMessage
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp = s.handle(r.Context(), &req)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handle(ctx context.Context, req *jsonrpcMessage) *jsonrpcMessage {
+	s.lock.Lock()
+	s.calls[req.Method]++
+	s.lock.Unlock()
+
+	resp := &jsonrpcMessage{Version: "2.0", ID: req.ID}
+	fixture, hasMethod, err := s.Fixtures.Lookup(req.Method, req.Params)
+	if err != nil {
+		resp.Error = &Error{Code: -32602, Message: err.Error()}
+		return resp
+	}
+
+	if fixture == nil && s.upstream != nil {
+		fixture, err = s.record(ctx, req)
+		if err != nil {
+			resp.Error = &Error{Code: -32603, Message: err.Error()}
+			return resp
+		}
+	}
+
+	switch {
+	case fixture != nil && fixture.Error != nil:
+		resp.Error = fixture.Error
+	case fixture != nil:
+		resp.Result = fixture.Result
+		if len(resp.Result) == 0 {
+			resp.Result = json.RawMessage("null")
+		}
+	case !hasMethod:
+		resp.Error = &Error{Code: -32601, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+	default:
+		resp.Error = &Error{Code: -32000, Message: fmt.Sprintf("rpctest: no fixture for %s %s", req.Method, req.Params)}
+	}
+	return resp
+}
+
+// record forwards the call to the upstream node and adds the response (result or JSON-RPC error) to the fixtures
+func (s *Server) record(ctx context.Context, req *jsonrpcMessage) (*Fixture, error) {
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+	}
+	args := make([]interface{}, len(params))
+	for i := range params {
+		args[i] = params[i]
+	}
+
+	fixture := &Fixture{Method: req.Method, Params: req.Params}
+	err := s.upstream.CallContext(ctx, &fixture.Result, req.Method, args...)
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		fixture.Error = &Error{Code: rpcErr.ErrorCode(), Message: rpcErr.Error()}
+	} else if err != nil {
+		return nil, err // not a response of the node, don't record
+	}
+
+	return fixture, s.Fixtures.add(fixture)
+}
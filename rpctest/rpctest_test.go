@@ -0,0 +1,499 @@
+package rpctest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"flag"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	checkpointoracle "github.com/ethereum/go-ethereum/contracts/checkpointoracle/contract"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm/runtime"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/metachris/eth-go-bindings/erc1155"
+	"github.com/metachris/eth-go-bindings/erc165"
+	"github.com/metachris/eth-go-bindings/erc20"
+	"github.com/metachris/eth-go-bindings/erc721"
+	"github.com/metachris/go-ethutils/smartcontracts"
+)
+
+// The fixtures in testdata are synthetic (no node is reachable from CI): a chain of blocks at mainnet heights and
+// timestamps, with signed transactions and ERC20 transfer logs, and a few contracts. Regenerate them with
+//
+//	go test ./rpctest -run TestGenerateFixtures -update
+var update = flag.Bool("update", false, "regenerate the fixtures in testdata")
+
+const (
+	chainFixtures    = "testdata/chain.json"
+	contractFixtures = "testdata/contracts.json"
+)
+
+// Synthetic chain
+const (
+	firstBlock    = 12323930
+	latestBlock   = 12323970
+	refBlock      = 12323940
+	refTimestamp  = 1619546404
+	blockInterval = 13
+)
+
+var (
+	tokenAddress    = common.HexToAddress("0x00000000000000000000000000000000000e2c20")
+	nftAddress      = common.HexToAddress("0x0000000000000000000000000000000000e2c721")
+	contractAddress = common.HexToAddress("0x00000000000000000000000000000000c0ffee00")
+	erc165Address   = common.HexToAddress("0x00000000000000000000000000000000000e2165")
+	erc1155Address  = common.HexToAddress("0x000000000000000000000000000000000e211550")
+	eoaAddress      = common.HexToAddress("0x0000000000000000000000000000000000000e0a")
+
+	// Proxies
+	eip1967Address     = common.HexToAddress("0x00000000000000000000000000000000000e1967")
+	proxyAdminAddress  = common.HexToAddress("0x00000000000000000000000000000000000000ad")
+	eip1167Address     = common.HexToAddress("0x00000000000000000000000000000000000e1167")
+	beaconProxyAddress = common.HexToAddress("0x00000000000000000000000000000000000beac0")
+	beaconAddress      = common.HexToAddress("0x00000000000000000000000000000000000beac1")
+	safeAddress        = common.HexToAddress("0x0000000000000000000000000000000000005afe")
+	safeMasterAddress  = common.HexToAddress("0x00000000000000000000000000000000005afe10")
+
+	// Real compiler output: OpenZeppelin tokens of eth-go-bindings (solc 0.8.4) and go-ethereum's checkpoint oracle
+	compiledTokenAddress  = common.HexToAddress("0x00000000000000000000000000000000000bc020")
+	compiledNftAddress    = common.HexToAddress("0x0000000000000000000000000000000000bc0721")
+	compiledItemsAddress  = common.HexToAddress("0x000000000000000000000000000000000bc01155")
+	compiledOracleAddress = common.HexToAddress("0x000000000000000000000000000000000bc0c0de")
+)
+
+func TestGenerateFixtures(t *testing.T) {
+	if !*update {
+		t.Skip("run with -update to regenerate the fixtures")
+	}
+
+	chain, err := generateChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chain.Save(chainFixtures); err != nil {
+		t.Fatal(err)
+	}
+
+	contracts, err := generateContracts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := contracts.Save(contractFixtures); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func generateChain() (*Fixtures, error) {
+	f := NewFixtures()
+	chainID := big.NewInt(1)
+	signer := types.NewLondonSigner(chainID)
+	keys := make([]*ecdsa.PrivateKey, 3)
+	for i := range keys {
+		keys[i], _ = crypto.ToECDSA(crypto.Keccak256([]byte{byte(i + 1)}))
+	}
+	transferTopic := crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+	parentHash := crypto.Keccak256Hash([]byte("rpctest"))
+	nonces := make([]uint64, len(keys))
+	for height := int64(firstBlock); height <= latestBlock; height++ {
+		header := &types.Header{
+			ParentHash: parentHash,
+			Coinbase:   common.HexToAddress("0x00000000000000000000000000000000000c0b5e"),
+			Root:       crypto.Keccak256Hash(big.NewInt(height).Bytes()),
+			Difficulty: big.NewInt(7000000000000000),
+			Number:     big.NewInt(height),
+			GasLimit:   30000000,
+			Time:       uint64(refTimestamp + (height-refBlock)*blockInterval),
+			BaseFee:    big.NewInt(30000000000),
+		}
+
+		var txs types.Transactions
+		var receipts types.Receipts
+		var cumulativeGas uint64
+		for i := 0; i < int(height%4); i++ {
+			keyIdx := (int(height) + i) % len(keys)
+			key := keys[keyIdx]
+			from := crypto.PubkeyToAddress(key.PublicKey)
+			to := crypto.PubkeyToAddress(keys[(keyIdx+1)%len(keys)].PublicKey)
+
+			var txData types.TxData
+			var logs []*types.Log
+			if i == 1 { // token transfer
+				amount := common.LeftPadBytes(big.NewInt(height*1000).Bytes(), 32)
+				txData = &types.DynamicFeeTx{ChainID: chainID, Nonce: nonces[keyIdx], GasTipCap: big.NewInt(2000000000), GasFeeCap: big.NewInt(100000000000), Gas: 60000, To: &tokenAddress, Data: append(common.FromHex("0xa9059cbb"), append(common.LeftPadBytes(to.Bytes(), 32), amount...)...)}
+				logs = []*types.Log{{
+					Address: tokenAddress,
+					Topics:  []common.Hash{transferTopic, common.BytesToHash(from.Bytes()), common.BytesToHash(to.Bytes())},
+					Data:    amount,
+				}}
+			} else if i == 2 {
+				txData = &types.LegacyTx{Nonce: nonces[keyIdx], GasPrice: big.NewInt(50000000000), Gas: 21000, To: &to, Value: big.NewInt(height)}
+			} else {
+				txData = &types.DynamicFeeTx{ChainID: chainID, Nonce: nonces[keyIdx], GasTipCap: big.NewInt(1000000000), GasFeeCap: big.NewInt(80000000000), Gas: 21000, To: &to, Value: big.NewInt(height * 1e9)}
+			}
+			nonces[keyIdx]++
+
+			tx, err := types.SignNewTx(key, signer, txData)
+			if err != nil {
+				return nil, err
+			}
+			txs = append(txs, tx)
+
+			gasUsed := tx.Gas()
+			cumulativeGas += gasUsed
+			receipt := &types.Receipt{Type: tx.Type(), Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: cumulativeGas, GasUsed: gasUsed, Logs: logs}
+			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+			receipts = append(receipts, receipt)
+		}
+		header.GasUsed = cumulativeGas
+
+		block := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+		if err := f.AddBlock(block, receipts, height == latestBlock); err != nil {
+			return nil, err
+		}
+		parentHash = block.Hash()
+	}
+	return f, nil
+}
+
+func generateContracts() (*Fixtures, error) {
+	f := NewFixtures()
+	erc20Abi, err := abi.JSON(strings.NewReader(erc20.Erc20ABI))
+	if err != nil {
+		return nil, err
+	}
+	erc721Abi, err := abi.JSON(strings.NewReader(erc721.Erc721ABI))
+	if err != nil {
+		return nil, err
+	}
+	erc1155Abi, err := abi.JSON(strings.NewReader(erc1155.Erc1155ABI))
+	if err != nil {
+		return nil, err
+	}
+
+	// addCall adds a successful call of a contract method
+	addCall := func(contract abi.ABI, address common.Address, method string, args []interface{}, results ...interface{}) error {
+		input, err := contract.Pack(method, args...)
+		if err != nil {
+			return err
+		}
+		output, err := contract.Methods[method].Outputs.Pack(results...)
+		if err != nil {
+			return err
+		}
+		return f.AddCall(ethereum.CallMsg{To: &address, Data: input}, output)
+	}
+
+	// Contract storage is empty, except for the slots the proxies set below
+	proxySlots := []common.Hash{{}, smartcontracts.SlotEip1967Implementation, smartcontracts.SlotEip1967Beacon, smartcontracts.SlotEip1967Admin,
+		smartcontracts.SlotEip1822Proxiable, smartcontracts.SlotOpenZeppelinImplementation, smartcontracts.SlotOpenZeppelinAdmin}
+	for _, address := range []common.Address{tokenAddress, nftAddress, erc1155Address, erc165Address, contractAddress, eip1967Address, beaconProxyAddress, safeAddress} {
+		for _, slot := range proxySlots {
+			if err := f.AddStorage(address, slot, common.Hash{}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	addressWord := func(address common.Address) []byte {
+		return common.LeftPadBytes(address.Bytes(), 32)
+	}
+
+	err = firstError(
+		// ERC20 token without ERC165 (supportsInterface calls fail)
+		f.AddCode(tokenAddress, common.FromHex("0x6080604052")),
+		addCall(erc20Abi, tokenAddress, "name", nil, "Test Token"),
+		addCall(erc20Abi, tokenAddress, "symbol", nil, "TST"),
+		addCall(erc20Abi, tokenAddress, "decimals", nil, uint8(18)),
+		addCall(erc20Abi, tokenAddress, "totalSupply", nil, new(big.Int).Exp(big.NewInt(10), big.NewInt(27), nil)),
+
+		// ERC721 token with metadata
+		f.AddCode(nftAddress, common.FromHex("0x6080604052")),
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc165}, true),
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc721}, true),
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc721Metadata}, true),
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc721Enumerable}, false),
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc1155}, false),
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{[4]byte{0x9a, 0x20, 0x48, 0x3d}}, false), // draft ERC721
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{[4]byte{0x0e, 0x89, 0x34, 0x1c}}, false), // ERC1155MetadataURI
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{[4]byte{0x2a, 0x55, 0x20, 0x5a}}, true),  // ERC2981
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{[4]byte{0x49, 0x06, 0x49, 0x06}}, false), // ERC4906
+		addCall(erc721Abi, nftAddress, "supportsInterface", []interface{}{[4]byte{0xff, 0xff, 0xff, 0xff}}, false),
+		addCall(erc721Abi, nftAddress, "name", nil, "Test NFT"),
+		addCall(erc721Abi, nftAddress, "symbol", nil, "TNFT"),
+
+		// ERC1155 collection with a name, but without symbol
+		f.AddCode(erc1155Address, common.FromHex("0x6080604052")),
+		addCall(erc1155Abi, erc1155Address, "supportsInterface", []interface{}{erc165.InterfaceIdErc165}, true),
+		addCall(erc1155Abi, erc1155Address, "supportsInterface", []interface{}{[4]byte{0xff, 0xff, 0xff, 0xff}}, false),
+		addCall(erc1155Abi, erc1155Address, "supportsInterface", []interface{}{erc165.InterfaceIdErc721}, false),
+		addCall(erc1155Abi, erc1155Address, "supportsInterface", []interface{}{[4]byte{0x9a, 0x20, 0x48, 0x3d}}, false),
+		addCall(erc1155Abi, erc1155Address, "supportsInterface", []interface{}{erc165.InterfaceIdErc1155}, true),
+		addCall(erc1155Abi, erc1155Address, "uri", []interface{}{big.NewInt(0)}, "https://items.example.com/api/{id}.json"),
+		addCall(erc721Abi, erc1155Address, "name", nil, "Test Items"),
+
+		// ERC165 contract which isn't an NFT
+		f.AddCode(erc165Address, common.FromHex("0x6080604052")),
+		addCall(erc721Abi, erc165Address, "supportsInterface", []interface{}{erc165.InterfaceIdErc165}, true),
+		addCall(erc721Abi, erc165Address, "supportsInterface", []interface{}{[4]byte{0xff, 0xff, 0xff, 0xff}}, false),
+		addCall(erc721Abi, erc165Address, "supportsInterface", []interface{}{erc165.InterfaceIdErc721}, false),
+		addCall(erc721Abi, erc165Address, "supportsInterface", []interface{}{[4]byte{0x9a, 0x20, 0x48, 0x3d}}, false),
+		addCall(erc721Abi, erc165Address, "supportsInterface", []interface{}{erc165.InterfaceIdErc1155}, false),
+
+		// EIP-1967 proxy of an ERC20 token
+		f.AddCode(eip1967Address, common.FromHex("0x6080604052")),
+		f.AddStorage(eip1967Address, smartcontracts.SlotEip1967Implementation, common.BytesToHash(tokenAddress.Bytes())),
+		f.AddStorage(eip1967Address, smartcontracts.SlotEip1967Admin, common.BytesToHash(proxyAdminAddress.Bytes())),
+		addCall(erc20Abi, eip1967Address, "name", nil, "Proxy Token"),
+		addCall(erc20Abi, eip1967Address, "symbol", nil, "PTK"),
+		addCall(erc20Abi, eip1967Address, "decimals", nil, uint8(6)),
+		addCall(erc20Abi, eip1967Address, "totalSupply", nil, big.NewInt(1e15)),
+
+		// EIP-1167 minimal proxy of the NFT contract
+		f.AddCode(eip1167Address, common.FromHex("0x363d3d373d3d3d363d73"+nftAddress.Hex()[2:]+"5af43d82803e903d91602b57fd5bf3")),
+
+		// EIP-1967 beacon proxy, the beacon returns the other contract as implementation
+		f.AddCode(beaconProxyAddress, common.FromHex("0x6080604052")),
+		f.AddStorage(beaconProxyAddress, smartcontracts.SlotEip1967Beacon, common.BytesToHash(beaconAddress.Bytes())),
+		f.AddCall(ethereum.CallMsg{To: &beaconAddress, Data: common.FromHex("0x5c60da1b")}, addressWord(contractAddress)),
+
+		// Gnosis Safe proxy
+		f.AddCode(safeAddress, common.FromHex("0x6080604052")),
+		f.AddStorage(safeAddress, common.Hash{}, common.BytesToHash(safeMasterAddress.Bytes())),
+		f.AddCall(ethereum.CallMsg{To: &safeAddress, Data: common.FromHex("0xa619486e")}, addressWord(safeMasterAddress)),
+
+		// Other contract, all calls fail
+		f.AddCode(contractAddress, common.FromHex("0x6080604052")),
+
+		// EOA
+		f.AddCode(eoaAddress, nil),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return f, addCompiledContracts(f, addCall)
+}
+
+// addCompiledContracts adds contracts with the runtime bytecode of real compiled contracts, for bytecode analysis
+func addCompiledContracts(f *Fixtures, addCall func(contract abi.ABI, address common.Address, method string, args []interface{}, results ...interface{}) error) error {
+	erc20Abi, erc721Abi, erc1155Abi, oracleAbi := abi.ABI{}, abi.ABI{}, abi.ABI{}, abi.ABI{}
+	for _, a := range []struct {
+		parsed *abi.ABI
+		json   string
+	}{{&erc20Abi, erc20.Erc20ABI}, {&erc721Abi, erc721.Erc721ABI}, {&erc1155Abi, erc1155.Erc1155ABI}, {&oracleAbi, checkpointoracle.CheckpointOracleABI}} {
+		parsed, err := abi.JSON(strings.NewReader(a.json))
+		if err != nil {
+			return err
+		}
+		*a.parsed = parsed
+	}
+
+	tokenCode, err := deployedCode(erc20Abi, erc20.Erc20Bin, "Compiled Token", "CTK")
+	if err != nil {
+		return err
+	}
+	nftCode, err := deployedCode(erc721Abi, erc721.Erc721Bin, "Compiled NFT", "CNFT")
+	if err != nil {
+		return err
+	}
+	itemsCode, err := deployedCode(erc1155Abi, erc1155.Erc1155Bin, "https://compiled.example.com/{id}.json")
+	if err != nil {
+		return err
+	}
+	oracleCode, err := deployedCode(oracleAbi, checkpointoracle.CheckpointOracleBin, []common.Address{eoaAddress}, big.NewInt(32768), big.NewInt(256), big.NewInt(1))
+	if err != nil {
+		return err
+	}
+
+	return firstError(
+		// ERC20 without ERC165
+		f.AddCode(compiledTokenAddress, tokenCode),
+		addCall(erc20Abi, compiledTokenAddress, "name", nil, "Compiled Token"),
+		addCall(erc20Abi, compiledTokenAddress, "symbol", nil, "CTK"),
+		addCall(erc20Abi, compiledTokenAddress, "decimals", nil, uint8(18)),
+		addCall(erc20Abi, compiledTokenAddress, "totalSupply", nil, big.NewInt(0)),
+
+		// ERC721 with metadata
+		f.AddCode(compiledNftAddress, nftCode),
+		addCall(erc721Abi, compiledNftAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc165}, true),
+		addCall(erc721Abi, compiledNftAddress, "supportsInterface", []interface{}{[4]byte{0xff, 0xff, 0xff, 0xff}}, false),
+		addCall(erc721Abi, compiledNftAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc721}, true),
+		addCall(erc721Abi, compiledNftAddress, "name", nil, "Compiled NFT"),
+		addCall(erc721Abi, compiledNftAddress, "symbol", nil, "CNFT"),
+
+		// ERC1155 without name and symbol
+		f.AddCode(compiledItemsAddress, itemsCode),
+		addCall(erc1155Abi, compiledItemsAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc165}, true),
+		addCall(erc1155Abi, compiledItemsAddress, "supportsInterface", []interface{}{[4]byte{0xff, 0xff, 0xff, 0xff}}, false),
+		addCall(erc1155Abi, compiledItemsAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc721}, false),
+		addCall(erc1155Abi, compiledItemsAddress, "supportsInterface", []interface{}{[4]byte{0x9a, 0x20, 0x48, 0x3d}}, false),
+		addCall(erc1155Abi, compiledItemsAddress, "supportsInterface", []interface{}{erc165.InterfaceIdErc1155}, true),
+		addCall(erc1155Abi, compiledItemsAddress, "uri", []interface{}{big.NewInt(0)}, "https://compiled.example.com/{id}.json"),
+
+		// Not a token, all calls fail
+		f.AddCode(compiledOracleAddress, oracleCode),
+	)
+}
+
+// deployedCode runs the constructor of a compiled contract in an in-memory EVM, and returns the runtime bytecode
+func deployedCode(contract abi.ABI, bin string, args ...interface{}) ([]byte, error) {
+	input, err := contract.Pack("", args...)
+	if err != nil {
+		return nil, err
+	}
+	code, _, _, err := runtime.Create(append(common.FromHex(bin), input...), &runtime.Config{})
+	return code, err
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestReplay(t *testing.T) {
+	fixtures, err := LoadFixtures(chainFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(fixtures)
+	defer server.Close()
+
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	latest, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Number.Int64() != latestBlock {
+		t.Errorf("latest block %d, expected %d", latest.Number, latestBlock)
+	}
+
+	block, err := client.BlockByNumber(ctx, big.NewInt(firstBlock+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(block.Transactions()) != 3 {
+		t.Fatalf("block %d has %d transactions, expected 3", block.Number(), len(block.Transactions()))
+	}
+	parent, err := client.HeaderByNumber(ctx, big.NewInt(firstBlock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.ParentHash() != parent.Hash() {
+		t.Error("parent hash mismatch")
+	}
+
+	receipt, err := client.TransactionReceipt(ctx, block.Transactions()[1].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.BlockHash != block.Hash() || len(receipt.Logs) != 1 || receipt.Logs[0].Address != tokenAddress {
+		t.Errorf("unexpected receipt %+v", receipt)
+	}
+
+	// Unknown params and unknown methods
+	if _, err := client.HeaderByNumber(ctx, big.NewInt(latestBlock+1)); err == nil || !strings.Contains(err.Error(), "no fixture") {
+		t.Errorf("expected missing fixture error, got %v", err)
+	}
+	if _, err := client.PendingNonceAt(ctx, eoaAddress); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("expected method not found error, got %v", err)
+	}
+
+	// Batch request
+	rpcClient, err := rpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+	var receipts []*types.Receipt
+	var missing *types.Header
+	batch := []rpc.BatchElem{
+		{Method: "eth_getBlockReceipts", Args: []interface{}{block.Hash()}, Result: &receipts},
+		{Method: "eth_getBlockByNumber", Args: []interface{}{"0x0", false}, Result: &missing},
+	}
+	if err := rpcClient.BatchCallContext(ctx, batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].Error != nil || len(receipts) != 3 {
+		t.Errorf("unexpected eth_getBlockReceipts response: %v %d", batch[0].Error, len(receipts))
+	}
+	if batch[1].Error == nil {
+		t.Error("expected error for missing block")
+	}
+
+	if n := server.Calls("eth_getBlockByNumber"); n != 5 {
+		t.Errorf("eth_getBlockByNumber called %d times, expected 5", n)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	upstreamFixtures, err := LoadFixtures(contractFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := NewServer(upstreamFixtures)
+	defer upstream.Close()
+
+	fixtures := NewFixtures()
+	recorder, err := NewRecordingServer(fixtures, upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+
+	client, err := ethclient.Dial(recorder.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	token, err := erc20.NewErc20Caller(tokenAddress, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if symbol, err := token.Symbol(nil); err != nil || symbol != "TST" {
+			t.Errorf("unexpected symbol %q, %v", symbol, err)
+		}
+	}
+	if _, err := token.BalanceOf(nil, eoaAddress); err == nil {
+		t.Error("expected error for call without fixture")
+	}
+	if n := upstream.Calls("eth_call"); n != 2 {
+		t.Errorf("upstream called %d times, expected 2 (second symbol call replayed)", n)
+	}
+
+	// Replay the recorded responses without upstream
+	upstream.Close()
+	replay := NewServer(fixtures)
+	defer replay.Close()
+	replayClient, err := ethclient.Dial(replay.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayClient.Close()
+
+	token, _ = erc20.NewErc20Caller(tokenAddress, replayClient)
+	if symbol, err := token.Symbol(nil); err != nil || symbol != "TST" {
+		t.Errorf("unexpected replayed symbol %q, %v", symbol, err)
+	}
+	if _, err := token.BalanceOf(nil, eoaAddress); err == nil {
+		t.Error("expected replayed error")
+	}
+}
@@ -0,0 +1,250 @@
+package smartcontracts
+
+import (
+	"bytes"
+	"context"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// ContractKind is a type of contract recognized from the function selectors in its bytecode
+type ContractKind string
+
+const (
+	ContractKindErc20         ContractKind = "ERC20"
+	ContractKindErc165        ContractKind = "ERC165"
+	ContractKindErc721        ContractKind = "ERC721"
+	ContractKindErc1155       ContractKind = "ERC1155"
+	ContractKindErc4626       ContractKind = "ERC4626"
+	ContractKindUniswapV2Pair ContractKind = "UniswapV2Pair"
+	ContractKindUniswapV3Pool ContractKind = "UniswapV3Pool"
+	ContractKindGnosisSafe    ContractKind = "GnosisSafe"
+)
+
+// MinBytecodeConfidence is the share of a kind's selectors which must be in the bytecode for ClassifyBytecode to
+// report it. The functions in ContractKindRequiredSignatures must be there in any case.
+const MinBytecodeConfidence = 0.6
+
+// ContractKindSignatures are the functions ClassifyBytecode looks for, by contract kind
+var ContractKindSignatures = map[ContractKind][]string{
+	ContractKindErc20: {"totalSupply()", "balanceOf(address)", "transfer(address,uint256)",
+		"transferFrom(address,address,uint256)", "approve(address,uint256)", "allowance(address,address)"},
+	ContractKindErc165: {"supportsInterface(bytes4)"},
+	ContractKindErc721: {"balanceOf(address)", "ownerOf(uint256)", "safeTransferFrom(address,address,uint256)",
+		"safeTransferFrom(address,address,uint256,bytes)", "transferFrom(address,address,uint256)",
+		"approve(address,uint256)", "setApprovalForAll(address,bool)", "getApproved(uint256)",
+		"isApprovedForAll(address,address)"},
+	ContractKindErc1155: {"balanceOf(address,uint256)", "balanceOfBatch(address[],uint256[])",
+		"setApprovalForAll(address,bool)", "isApprovedForAll(address,address)",
+		"safeTransferFrom(address,address,uint256,uint256,bytes)",
+		"safeBatchTransferFrom(address,address,uint256[],uint256[],bytes)"},
+	ContractKindErc4626: {"asset()", "totalAssets()", "convertToShares(uint256)", "convertToAssets(uint256)",
+		"maxDeposit(address)", "previewDeposit(uint256)", "deposit(uint256,address)", "maxMint(address)",
+		"previewMint(uint256)", "mint(uint256,address)", "maxWithdraw(address)", "previewWithdraw(uint256)",
+		"withdraw(uint256,address,address)", "maxRedeem(address)", "previewRedeem(uint256)",
+		"redeem(uint256,address,address)"},
+	ContractKindUniswapV2Pair: {"token0()", "token1()", "factory()", "getReserves()", "price0CumulativeLast()",
+		"price1CumulativeLast()", "kLast()", "mint(address)", "burn(address)", "swap(uint256,uint256,address,bytes)",
+		"skim(address)", "sync()"},
+	ContractKindUniswapV3Pool: {"token0()", "token1()", "factory()", "fee()", "tickSpacing()", "maxLiquidityPerTick()",
+		"slot0()", "liquidity()", "ticks(int24)", "positions(bytes32)", "observations(uint256)", "observe(uint32[])",
+		"mint(address,int24,int24,uint128,bytes)", "burn(int24,int24,uint128)",
+		"collect(address,int24,int24,uint128,uint128)", "swap(address,bool,int256,uint160,bytes)",
+		"flash(address,uint256,uint256,bytes)"},
+	ContractKindGnosisSafe: {"execTransaction(address,uint256,bytes,uint8,uint256,uint256,uint256,address,address,bytes)",
+		"getOwners()", "getThreshold()", "isOwner(address)", "nonce()", "addOwnerWithThreshold(address,uint256)",
+		"removeOwner(address,address,uint256)", "swapOwner(address,address,address)", "changeThreshold(uint256)",
+		"enableModule(address)", "disableModule(address,address)"},
+}
+
+// ContractKindRequiredSignatures are the functions without which ClassifyBytecode doesn't report a kind, whatever its
+// confidence. An ERC721 contract has the selectors of balanceOf, approve and transferFrom of ERC20, an
+// ERC721Enumerable one also totalSupply, but neither has transfer or allowance.
+var ContractKindRequiredSignatures = map[ContractKind][]string{
+	ContractKindErc20: {"transfer(address,uint256)", "allowance(address,address)"},
+}
+
+// BytecodeMatch is a contract kind recognized in bytecode
+type BytecodeMatch struct {
+	Kind       ContractKind
+	Confidence float64  // share of the kind's selectors found in the bytecode, 0-1
+	Missing    []string // signatures of the functions not found
+}
+
+// Bytecode opcodes
+const (
+	opEq           = 0x14
+	opPush1        = 0x60
+	opPush4        = 0x63
+	opPush32       = 0x7f
+	opDelegateCall = 0xf4
+)
+
+// scanBytecode walks the instructions of runtime bytecode, and returns the candidate function selectors and whether it
+// delegates calls. Selectors are the PUSH4 values, plus shorter pushes compared with EQ: the compiler drops leading
+// zero bytes, eg. 0x00fdd58e (balanceOf(address,uint256) of ERC1155) is pushed with PUSH3.
+func scanBytecode(code []byte) (selectors map[[4]byte]bool, hasDelegateCall bool) {
+	code = stripSolidityMetadata(code)
+	selectors = make(map[[4]byte]bool)
+	for pc := 0; pc < len(code); pc++ {
+		op := code[pc]
+		if op == opDelegateCall {
+			hasDelegateCall = true
+		}
+		if op < opPush1 || op > opPush32 {
+			continue
+		}
+
+		size := int(op-opPush1) + 1
+		if pc+size >= len(code) {
+			break // truncated push at the end of the code
+		}
+		value := code[pc+1 : pc+1+size]
+		pc += size
+		if op == opPush4 || (op < opPush4 && pc+1 < len(code) && code[pc+1] == opEq) {
+			var selector [4]byte
+			copy(selector[4-size:], value)
+			selectors[selector] = true
+		}
+	}
+	return selectors, hasDelegateCall
+}
+
+// stripSolidityMetadata removes the CBOR-encoded metadata the Solidity compiler appends to the code, whose length is
+// in the last two bytes, so that its bytes aren't read as instructions
+func stripSolidityMetadata(code []byte) []byte {
+	if len(code) < 2 {
+		return code
+	}
+	metadataLength := int(code[len(code)-2])<<8 | int(code[len(code)-1])
+	start := len(code) - 2 - metadataLength
+	if metadataLength == 0 || start < 0 || code[start] < 0xa1 || code[start] > 0xa5 { // CBOR map with 1-5 entries
+		return code
+	}
+	return code[:start]
+}
+
+// ExtractSelectors returns the function selectors in runtime bytecode, sorted. Besides the dispatcher's selectors, it
+// may contain other 4-byte constants of the code.
+func ExtractSelectors(code []byte) [][4]byte {
+	selectorSet, _ := scanBytecode(code)
+	selectors := make([][4]byte, 0, len(selectorSet))
+	for selector := range selectorSet {
+		selectors = append(selectors, selector)
+	}
+	sort.Slice(selectors, func(i, j int) bool { return bytes.Compare(selectors[i][:], selectors[j][:]) < 0 })
+	return selectors
+}
+
+// ClassifyBytecode matches the function selectors in runtime bytecode against the selectors of known contract kinds
+// (ContractKindSignatures), and returns the kinds with a confidence of at least MinBytecodeConfidence and all required
+// functions (ContractKindRequiredSignatures), the most likely first. A contract can match several kinds (eg. an ERC4626
+// vault or a Uniswap V2 pair is also an ERC20 token).
+//
+// It doesn't make any calls, but can't see through proxies (whose code only delegates calls) and doesn't recognize
+// code without a selector dispatcher (eg. newer Vyper contracts, which use a jump table).
+func ClassifyBytecode(code []byte) []BytecodeMatch {
+	selectors, _ := scanBytecode(code)
+	return classifySelectors(selectors)
+}
+
+func hasSelector(selectors map[[4]byte]bool, signature string) bool {
+	var selector [4]byte
+	copy(selector[:], crypto.Keccak256([]byte(signature)))
+	return selectors[selector]
+}
+
+func classifySelectors(selectors map[[4]byte]bool) []BytecodeMatch {
+	matches := []BytecodeMatch{}
+kinds:
+	for kind, signatures := range ContractKindSignatures {
+		for _, signature := range ContractKindRequiredSignatures[kind] {
+			if !hasSelector(selectors, signature) {
+				continue kinds
+			}
+		}
+
+		match := BytecodeMatch{Kind: kind}
+		for _, signature := range signatures {
+			if !hasSelector(selectors, signature) {
+				match.Missing = append(match.Missing, signature)
+			}
+		}
+		match.Confidence = float64(len(signatures)-len(match.Missing)) / float64(len(signatures))
+		if match.Confidence >= MinBytecodeConfidence {
+			matches = append(matches, match)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Confidence != matches[j].Confidence {
+			return matches[i].Confidence > matches[j].Confidence
+		}
+		return matches[i].Kind < matches[j].Kind
+	})
+	return matches
+}
+
+// ClassifyContract gets the code of a contract and classifies it with ClassifyBytecode
+func ClassifyContract(address string, client ethrpc.CodeReader) ([]BytecodeMatch, error) {
+	code, err := client.CodeAt(context.Background(), common.HexToAddress(address), nil)
+	if err != nil {
+		return nil, err
+	}
+	return ClassifyBytecode(code), nil
+}
+
+func hasKind(matches []BytecodeMatch, kind ContractKind) bool {
+	for _, match := range matches {
+		if match.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAddressDetailWithBytecodePrefilter is like GetAddressDetailFromBlockchain, but classifies the bytecode first
+// (see ClassifyBytecode) and only makes the calls of the token standards the bytecode matches. Contracts which
+// delegate calls (possibly proxies) or have no recognizable selectors get the full detection.
+func GetAddressDetailWithBytecodePrefilter(address string, client ethrpc.ContractReader) (detail addressdetail.AddressDetail, found bool) {
+	ctx := context.Background()
+	addr := common.HexToAddress(address)
+	code, err := client.CodeAt(ctx, addr, nil)
+	if err != nil {
+		return GetAddressDetailFromBlockchain(address, client)
+	}
+	detail = addressdetail.NewAddressDetail(address)
+	if len(code) == 0 {
+		detail.Type = addressdetail.AddressTypeEOA
+		return detail, false
+	}
+
+	selectors, hasDelegateCall := scanBytecode(code)
+	if hasDelegateCall || len(selectors) == 0 {
+		return GetAddressDetailFromBlockchain(address, client)
+	}
+
+	matches := classifySelectors(selectors)
+	if hasKind(matches, ContractKindErc721) || hasKind(matches, ContractKindErc1155) {
+		if isErc165, _ := IsErc165(address, client); isErc165 {
+			if isErc721, detail, _ := isErc165Erc721(address, client); isErc721 {
+				return detail, true
+			}
+			if isErc1155, detail, _ := isErc165Erc1155(address, client); isErc1155 {
+				return detail, true
+			}
+		}
+	}
+	if hasKind(matches, ContractKindErc20) {
+		if isErc20, detail, _ := IsErc20(address, client); isErc20 {
+			return detail, true
+		}
+	}
+
+	// Without delegatecall it can't be a proxy
+	detail.Type = addressdetail.AddressTypeOtherContract
+	return detail, true
+}
@@ -0,0 +1,131 @@
+package smartcontracts_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/metachris/go-ethutils/smartcontracts"
+)
+
+func TestExtractSelectors(t *testing.T) {
+	code := common.FromHex("0x" +
+		"8063a9059cbb1461010057" + // DUP1 PUSH4 transfer(address,uint256) EQ PUSH2 JUMPI
+		"8062fdd58e1461010057" + // DUP1 PUSH3 balanceOf(address,uint256) without the leading zero EQ PUSH2 JUMPI
+		"60ff16" + // PUSH1 0xff AND, not a selector
+		"7f63deadbeef000000000000000000000000000000000000000000000000000000" + // PUSH4 in PUSH32 data, not a selector
+		"fe" + // INVALID
+		"a1646970667358221220" + strings.Repeat("63", 32) + "002a") // metadata {"ipfs": ...} full of PUSH4 opcodes
+
+	expected := [][4]byte{{0x00, 0xfd, 0xd5, 0x8e}, {0xa9, 0x05, 0x9c, 0xbb}}
+	if selectors := smartcontracts.ExtractSelectors(code); !reflect.DeepEqual(selectors, expected) {
+		t.Errorf("got selectors %x, expected %x", selectors, expected)
+	}
+}
+
+// dispatcher returns code which dispatches calls to the functions like the Solidity compiler does
+func dispatcher(signatures ...string) []byte {
+	code := common.FromHex("0x60003560e01c") // PUSH1 0 CALLDATALOAD PUSH1 0xe0 SHR
+	for _, signature := range signatures {
+		selector := crypto.Keccak256([]byte(signature))[:4]
+		code = append(append(append(code, 0x80, 0x63), selector...), 0x14, 0x61, 0x01, 0x00, 0x57) // DUP1 PUSH4 EQ PUSH2 JUMPI
+	}
+	return code
+}
+
+func TestClassifyBytecode(t *testing.T) {
+	client := newContractClient(t)
+
+	tests := []struct {
+		address string
+		kinds   []smartcontracts.ContractKind
+	}{
+		{compiledTokenAddress, []smartcontracts.ContractKind{smartcontracts.ContractKindErc20}},
+		// ERC721 shares balanceOf, approve and transferFrom with ERC20, but isn't classified as ERC20
+		{compiledNftAddress, []smartcontracts.ContractKind{smartcontracts.ContractKindErc165, smartcontracts.ContractKindErc721}},
+		{compiledItemsAddress, []smartcontracts.ContractKind{smartcontracts.ContractKindErc1155, smartcontracts.ContractKindErc165}},
+		{compiledOracleAddress, nil},
+		{eip1167Address, nil},  // minimal proxy, only delegates
+		{contractAddress, nil}, // no dispatcher
+		{eoaAddress, nil},
+	}
+	for _, tt := range tests {
+		matches, err := smartcontracts.ClassifyContract(tt.address, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var kinds []smartcontracts.ContractKind
+		for _, match := range matches {
+			kinds = append(kinds, match.Kind)
+			if match.Confidence != 1 || len(match.Missing) != 0 {
+				t.Errorf("%s: expected all selectors of %s, got %+v", tt.address, match.Kind, match)
+			}
+		}
+		if !reflect.DeepEqual(kinds, tt.kinds) {
+			t.Errorf("%s: got kinds %v, expected %v", tt.address, kinds, tt.kinds)
+		}
+	}
+
+	// An ERC721Enumerable contract has 4 of the 6 ERC20 functions (totalSupply, balanceOf, transferFrom and approve)
+	enumerable := append([]string{"totalSupply()", "tokenOfOwnerByIndex(address,uint256)", "tokenByIndex(uint256)",
+		"name()", "symbol()", "tokenURI(uint256)"}, smartcontracts.ContractKindSignatures[smartcontracts.ContractKindErc721]...)
+	enumerable = append(enumerable, smartcontracts.ContractKindSignatures[smartcontracts.ContractKindErc165]...)
+	var kinds []smartcontracts.ContractKind
+	for _, match := range smartcontracts.ClassifyBytecode(dispatcher(enumerable...)) {
+		kinds = append(kinds, match.Kind)
+	}
+	if expected := []smartcontracts.ContractKind{smartcontracts.ContractKindErc165, smartcontracts.ContractKindErc721}; !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("ERC721Enumerable: got kinds %v, expected %v", kinds, expected)
+	}
+
+	// The real compiler output has all selectors of the ABI, including 0x00fdd58e which is pushed with PUSH3
+	code, err := client.CodeAt(context.Background(), common.HexToAddress(compiledItemsAddress), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	selectors := smartcontracts.ExtractSelectors(code)
+	if len(selectors) == 0 || selectors[0] != [4]byte{0x00, 0xfd, 0xd5, 0x8e} {
+		t.Errorf("balanceOf(address,uint256) not found in %x", selectors)
+	}
+}
+
+func TestClassifyBytecodeConfidence(t *testing.T) {
+	// An ERC20 token with 12 of the 16 ERC4626 functions
+	signatures := append([]string{}, smartcontracts.ContractKindSignatures[smartcontracts.ContractKindErc20]...)
+	signatures = append(signatures, smartcontracts.ContractKindSignatures[smartcontracts.ContractKindErc4626][:12]...)
+	matches := smartcontracts.ClassifyBytecode(dispatcher(signatures...))
+	if len(matches) != 2 {
+		t.Fatalf("got %d matches, expected ERC20 and ERC4626: %+v", len(matches), matches)
+	}
+	if matches[0].Kind != smartcontracts.ContractKindErc20 || matches[0].Confidence != 1 {
+		t.Errorf("unexpected first match %+v", matches[0])
+	}
+	if matches[1].Kind != smartcontracts.ContractKindErc4626 || matches[1].Confidence != 0.75 || len(matches[1].Missing) != 4 {
+		t.Errorf("unexpected second match %+v", matches[1])
+	}
+}
+
+func TestGetAddressDetailWithBytecodePrefilter(t *testing.T) {
+	client := &multicallClient{Client: newContractClient(t)}
+
+	addresses := []string{compiledTokenAddress, compiledNftAddress, compiledItemsAddress, compiledOracleAddress,
+		tokenAddress, nftAddress, erc1155Address, erc165Address, contractAddress, eoaAddress, eip1967Address, eip1167Address}
+	for _, address := range addresses {
+		expected, expectedFound := smartcontracts.GetAddressDetailFromBlockchain(address, client.Client)
+
+		client.otherCalls = 0
+		detail, found := smartcontracts.GetAddressDetailWithBytecodePrefilter(address, client)
+		if detail != expected || found != expectedFound {
+			t.Errorf("%s: got %+v, expected %+v", address, detail, expected)
+		}
+
+		// The ERC20 token only needs the ERC20 calls, contracts which match no token standard none at all
+		expectedCalls := map[string]int64{compiledTokenAddress: 4, compiledOracleAddress: 0, eoaAddress: 0}
+		if calls, found := expectedCalls[address]; found && client.otherCalls != calls {
+			t.Errorf("%s: %d eth_calls, expected %d", address, client.otherCalls, calls)
+		}
+	}
+}
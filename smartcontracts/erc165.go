@@ -0,0 +1,79 @@
+package smartcontracts
+
+import (
+	"github.com/metachris/eth-go-bindings/erc165"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// Interface IDs which are not in erc165
+var (
+	InterfaceIdInvalid            = [4]byte{0xff, 0xff, 0xff, 0xff} // must not be supported by ERC165 contracts
+	InterfaceIdErc721Legacy       = [4]byte{0x9a, 0x20, 0x48, 0x3d} // draft ERC721, eg. CryptoKitties
+	InterfaceIdErc1155MetadataURI = [4]byte{0x0e, 0x89, 0x34, 0x1c}
+	InterfaceIdErc2981            = [4]byte{0x2a, 0x55, 0x20, 0x5a} // NFT royalties
+	InterfaceIdErc4906            = [4]byte{0x49, 0x06, 0x49, 0x06} // metadata update events
+)
+
+// Interface is an interface ID with a human-readable name
+type Interface struct {
+	Name string
+	ID   [4]byte
+}
+
+// DefaultInterfaces are the interfaces GetSupportedInterfaces probes if none are given
+var DefaultInterfaces = []Interface{
+	{"ERC721", erc165.InterfaceIdErc721},
+	{"ERC721Metadata", erc165.InterfaceIdErc721Metadata},
+	{"ERC721Enumerable", erc165.InterfaceIdErc721Enumerable},
+	{"ERC721Legacy", InterfaceIdErc721Legacy},
+	{"ERC1155", erc165.InterfaceIdErc1155},
+	{"ERC1155MetadataURI", InterfaceIdErc1155MetadataURI},
+	{"ERC2981", InterfaceIdErc2981},
+	{"ERC4906", InterfaceIdErc4906},
+}
+
+// IsErc165 checks whether a contract implements ERC165 as specified: supportsInterface(0x01ffc9a7) returns true and
+// supportsInterface(0xffffffff) returns false. Contracts without supportsInterface (calls revert) are not ERC165,
+// err is only returned for transient errors (see ethrpc.IsTransientError).
+func IsErc165(address string, client ethrpc.ContractReader) (isErc165 bool, err error) {
+	isErc165, err = supportsInterface(address, erc165.InterfaceIdErc165, client)
+	if err != nil || !isErc165 {
+		return false, err
+	}
+
+	supportsInvalid, err := supportsInterface(address, InterfaceIdInvalid, client)
+	return err == nil && !supportsInvalid, err
+}
+
+// GetSupportedInterfaces returns which of the given interfaces (DefaultInterfaces if none are given) a contract
+// supports. Returns isErc165 false and no interfaces if the contract doesn't implement ERC165 (see IsErc165).
+func GetSupportedInterfaces(address string, client ethrpc.ContractReader, interfaces ...Interface) (isErc165 bool, supported []Interface, err error) {
+	isErc165, err = IsErc165(address, client)
+	if err != nil || !isErc165 {
+		return false, nil, err
+	}
+
+	if len(interfaces) == 0 {
+		interfaces = DefaultInterfaces
+	}
+	for _, iface := range interfaces {
+		isSupported, err := supportsInterface(address, iface.ID, client)
+		if err != nil {
+			return true, supported, err
+		}
+		if isSupported {
+			supported = append(supported, iface)
+		}
+	}
+	return true, supported, nil
+}
+
+// supportsInterface is like SmartContractSupportsInterface, but a failing call only counts as unsupported unless the
+// error is transient
+func supportsInterface(address string, interfaceId [4]byte, client ethrpc.ContractReader) (bool, error) {
+	supported, err := SmartContractSupportsInterface(address, interfaceId, client)
+	if err != nil && !ethrpc.IsTransientError(err) {
+		return false, nil
+	}
+	return supported, err
+}
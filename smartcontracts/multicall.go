@@ -0,0 +1,414 @@
+package smartcontracts
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/eth-go-bindings/erc1155"
+	"github.com/metachris/eth-go-bindings/erc165"
+	"github.com/metachris/eth-go-bindings/erc20"
+	"github.com/metachris/eth-go-bindings/erc721"
+	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// Multicall3Address is the address of the Multicall3 contract on mainnet and most other chains (see multicall3.com)
+var Multicall3Address = common.HexToAddress("0xcA11bde05977b3631167028862bE2a173976CA11")
+
+const multicall3ABI = `[{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3[]","name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+
+// Parsed ABIs of the batched calls
+var (
+	multicall3Abi = mustParseABI(multicall3ABI)
+	erc20Abi      = mustParseABI(erc20.Erc20ABI)
+	erc721Abi     = mustParseABI(erc721.Erc721ABI)
+	erc1155Abi    = mustParseABI(erc1155.Erc1155ABI)
+)
+
+func mustParseABI(s string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// Call is a contract call in a Multicall3 batch
+type Call struct {
+	Target   common.Address
+	CallData []byte
+}
+
+// CallResult is the result of a call in a Multicall3 batch. Failed calls have Success false.
+type CallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// Multicall3 executes all calls in a single eth_call of aggregate3 at the latest block. Each call may fail without
+// failing the others.
+func Multicall3(ctx context.Context, client ethrpc.ContractCaller, multicallAddress common.Address, calls []Call) ([]CallResult, error) {
+	type call3 struct {
+		Target       common.Address
+		AllowFailure bool
+		CallData     []byte
+	}
+	calls3 := make([]call3, len(calls))
+	for i, c := range calls {
+		calls3[i] = call3{Target: c.Target, AllowFailure: true, CallData: c.CallData}
+	}
+
+	input, err := multicall3Abi.Pack("aggregate3", calls3)
+	if err != nil {
+		return nil, err
+	}
+	output, err := client.CallContract(ctx, ethereum.CallMsg{To: &multicallAddress, Data: input}, nil)
+	if err != nil {
+		return nil, err
+	}
+	values, err := multicall3Abi.Unpack("aggregate3", output)
+	if err != nil {
+		return nil, fmt.Errorf("decoding aggregate3 result: %v", err)
+	}
+
+	results := *abi.ConvertType(values[0], new([]CallResult)).(*[]CallResult)
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("aggregate3 returned %d results for %d calls", len(results), len(calls))
+	}
+	return results, nil
+}
+
+// DefaultAddressesPerMulticall is the number of addresses GetAddressDetailsFromBlockchain classifies per aggregate3 call
+const DefaultAddressesPerMulticall = 50
+
+// BatchOptions configures GetAddressDetailsFromBlockchain
+type BatchOptions struct {
+	Multicall3Address common.Address // defaults to Multicall3Address
+	AddressesPerCall  int            // addresses per aggregate3 call (with 11 calls each), defaults to DefaultAddressesPerMulticall
+
+	// DetectProxies also detects proxy contracts and their implementation, like GetAddressDetailWithProxy (requires a
+	// client which can read storage, like *ethclient.Client)
+	DetectProxies bool
+
+	// OnChunkError is called if the aggregate3 call of a chunk of addresses fails (eg. a contract in it burns all gas),
+	// or the code of one of them can't be fetched. These addresses are then classified one by one. Optional.
+	OnChunkError func(addresses []string, err error)
+
+	// RPC is an optional raw JSON-RPC connection to the same node, used to get code and proxy storage slots of all
+	// addresses in batch requests. Not needed if the client has its own (like *ethrpc.Client and *ethrpc.Pool).
+	// Without it, they are queried one by one.
+	RPC ethrpc.RPCCaller
+}
+
+// Calls made for each address in the aggregate3 batch (index of the call per address)
+const (
+	callSupportsErc165 = iota
+	callSupportsInvalid
+	callSupportsErc721
+	callSupportsErc721Legacy
+	callSupportsErc1155
+	callName
+	callSymbol
+	callDecimals
+	callTotalSupply
+	callUri
+	callMasterCopy
+	numBatchCalls
+)
+
+var batchCalls = func() [][]byte {
+	calls := make([][]byte, numBatchCalls)
+	calls[callSupportsErc165] = packCall(erc721Abi, "supportsInterface", erc165.InterfaceIdErc165)
+	calls[callSupportsInvalid] = packCall(erc721Abi, "supportsInterface", InterfaceIdInvalid)
+	calls[callSupportsErc721] = packCall(erc721Abi, "supportsInterface", erc165.InterfaceIdErc721)
+	calls[callSupportsErc721Legacy] = packCall(erc721Abi, "supportsInterface", InterfaceIdErc721Legacy)
+	calls[callSupportsErc1155] = packCall(erc721Abi, "supportsInterface", erc165.InterfaceIdErc1155)
+	calls[callName] = packCall(erc20Abi, "name")
+	calls[callSymbol] = packCall(erc20Abi, "symbol")
+	calls[callDecimals] = packCall(erc20Abi, "decimals")
+	calls[callTotalSupply] = packCall(erc20Abi, "totalSupply")
+	calls[callUri] = packCall(erc1155Abi, "uri", big.NewInt(0))
+	calls[callMasterCopy] = selectorMasterCopy
+	return calls
+}()
+
+func packCall(contractAbi abi.ABI, method string, args ...interface{}) []byte {
+	input, err := contractAbi.Pack(method, args...)
+	if err != nil {
+		panic(err)
+	}
+	return input
+}
+
+// GetAddressDetailsFromBlockchain classifies many addresses like GetAddressDetailFromBlockchain (or
+// GetAddressDetailWithProxy with BatchOptions.DetectProxies), with the same results, but with only a few calls: the ERC165/20/721/1155 calls of DefaultAddressesPerMulticall addresses are
+// batched into a single aggregate3 call of the Multicall3 contract, and code and proxy storage slots are fetched with
+// JSON-RPC batch requests of up to 100 calls (if a raw JSON-RPC connection is available, else one by one). If the
+// aggregate3 call of a chunk fails (eg. no Multicall3 contract on the chain, or a contract which burns all gas), the
+// addresses of the chunk are classified one by one. Addresses which are not contracts have the type AddressTypeEOA.
+func GetAddressDetailsFromBlockchain(addresses []string, client ethrpc.ContractReader, opts BatchOptions) []addressdetail.AddressDetail {
+	if opts.Multicall3Address == (common.Address{}) {
+		opts.Multicall3Address = Multicall3Address
+	}
+	if opts.AddressesPerCall < 1 {
+		opts.AddressesPerCall = DefaultAddressesPerMulticall
+	}
+	if opts.RPC == nil {
+		opts.RPC = ethrpc.GetRPCCaller(client)
+	}
+
+	details := make([]addressdetail.AddressDetail, 0, len(addresses))
+	for start := 0; start < len(addresses); start += opts.AddressesPerCall {
+		end := start + opts.AddressesPerCall
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+		chunk, err := getAddressDetailsChunk(addresses[start:end], client, opts)
+		if err != nil {
+			if opts.OnChunkError != nil {
+				opts.OnChunkError(addresses[start:end], err)
+			}
+			chunk = getAddressDetailsOneByOne(addresses[start:end], client, opts.DetectProxies)
+		}
+		details = append(details, chunk...)
+	}
+	return details
+}
+
+// getAddressDetailsOneByOne is the fallback for chunks whose batched calls failed
+func getAddressDetailsOneByOne(addresses []string, client ethrpc.ContractReader, detectProxies bool) []addressdetail.AddressDetail {
+	details := make([]addressdetail.AddressDetail, len(addresses))
+	storageClient, canReadStorage := client.(ethrpc.ContractStorageReader)
+	for i, address := range addresses {
+		if detectProxies && canReadStorage {
+			details[i], _ = GetAddressDetailWithProxy(address, storageClient)
+		} else {
+			details[i], _ = GetAddressDetailFromBlockchain(address, client)
+		}
+	}
+	return details
+}
+
+func getAddressDetailsChunk(addresses []string, client ethrpc.ContractReader, opts BatchOptions) ([]addressdetail.AddressDetail, error) {
+	ctx := context.Background()
+
+	calls := make([]Call, 0, len(addresses)*numBatchCalls)
+	for _, address := range addresses {
+		for _, callData := range batchCalls {
+			calls = append(calls, Call{Target: common.HexToAddress(address), CallData: callData})
+		}
+	}
+	results, err := Multicall3(ctx, client, opts.Multicall3Address, calls)
+	if err != nil {
+		return nil, err
+	}
+
+	details := make([]addressdetail.AddressDetail, len(addresses))
+	masterCopies := make([]common.Address, len(addresses))
+	for i, address := range addresses {
+		r := results[i*numBatchCalls : (i+1)*numBatchCalls]
+		details[i] = classifyFromResults(address, r)
+		if r[callMasterCopy].Success {
+			masterCopies[i] = wordToAddress(r[callMasterCopy].ReturnData)
+		}
+	}
+
+	// Code (to tell contracts from EOAs) and proxy storage slots, in batches if possible
+	storageClient, canReadStorage := client.(ethrpc.ContractStorageReader)
+	detectProxies := opts.DetectProxies && canReadStorage
+	codes := make([][]byte, len(addresses))
+	slots := make([]map[common.Hash][]byte, len(addresses))
+	if opts.RPC != nil {
+		codes, slots = batchGetCodeAndSlots(ctx, opts.RPC, addresses, detectProxies)
+	}
+
+	for i, address := range addresses {
+		addr := common.HexToAddress(address)
+		needsCode := details[i].Type == addressdetail.AddressTypeInit || detectProxies
+		if codes[i] == nil && needsCode { // not fetched in a batch, or the batch failed
+			code, err := client.CodeAt(ctx, addr, nil)
+			if err != nil {
+				return nil, err
+			}
+			codes[i] = code
+		}
+
+		if details[i].Type == addressdetail.AddressTypeInit {
+			details[i].Type = addressdetail.AddressTypeEOA
+			if len(codes[i]) > 0 {
+				details[i].Type = addressdetail.AddressTypeOtherContract
+			}
+		}
+		if !detectProxies || len(codes[i]) == 0 {
+			continue
+		}
+
+		var src proxySource = &clientProxySource{ctx: ctx, client: storageClient, address: addr}
+		if slots[i] != nil {
+			src = &prefetchedProxySource{slots: slots[i], master: masterCopies[i], client: client}
+		}
+		if proxy, isProxy, _ := detectProxy(codes[i], src); isProxy {
+			details[i].ProxyType = proxy.Type
+			details[i].Implementation = proxy.Implementation.Hex()
+		}
+	}
+	return details, nil
+}
+
+// classifyFromResults does the ERC165/721/1155/20 classification of GetAddressDetailFromBlockchain with the results
+// of the batched calls. The type stays AddressTypeInit if it's none of those.
+func classifyFromResults(address string, r []CallResult) addressdetail.AddressDetail {
+	detail := addressdetail.NewAddressDetail(address)
+	boolResult := func(i int) bool {
+		if !r[i].Success {
+			return false
+		}
+		values, err := erc721Abi.Unpack("supportsInterface", r[i].ReturnData)
+		return err == nil && values[0].(bool)
+	}
+	stringResult := func(i int, contractAbi abi.ABI, method string) string {
+		if !r[i].Success {
+			return ""
+		}
+		values, err := contractAbi.Unpack(method, r[i].ReturnData)
+		if err != nil {
+			return ""
+		}
+		return values[0].(string)
+	}
+
+	supportsErc165 := boolResult(callSupportsErc165)
+	supportsInvalid := boolResult(callSupportsInvalid)
+	if supportsErc165 && !supportsInvalid {
+		isErc721 := boolResult(callSupportsErc721)
+		isLegacyErc721 := boolResult(callSupportsErc721Legacy)
+		isErc1155 := boolResult(callSupportsErc1155)
+		switch {
+		case isErc721 || isLegacyErc721:
+			detail.Type = addressdetail.AddressTypeErc721
+		case isErc1155:
+			detail.Type = addressdetail.AddressTypeErc1155
+			detail.MetadataURI = stringResult(callUri, erc1155Abi, "uri")
+		}
+		if detail.Type != addressdetail.AddressTypeInit {
+			detail.Name = stringResult(callName, erc721Abi, "name")
+			detail.Symbol = stringResult(callSymbol, erc721Abi, "symbol")
+			return detail
+		}
+	}
+
+	// ERC20 needs name, symbol, decimals and totalSupply
+	name := stringResult(callName, erc20Abi, "name")
+	symbol := stringResult(callSymbol, erc20Abi, "symbol")
+	if name == "" || symbol == "" || !r[callDecimals].Success || !r[callTotalSupply].Success {
+		return detail
+	}
+	decimals, err := erc20Abi.Unpack("decimals", r[callDecimals].ReturnData)
+	if err != nil {
+		return detail
+	}
+	if _, err := erc20Abi.Unpack("totalSupply", r[callTotalSupply].ReturnData); err != nil {
+		return detail
+	}
+	detail.Type = addressdetail.AddressTypeErc20
+	detail.Name = name
+	detail.Symbol = symbol
+	detail.Decimals = decimals[0].(uint8)
+	return detail
+}
+
+// Storage slots read for proxy detection
+var proxySlots = []common.Hash{SlotEip1967Implementation, SlotEip1967Beacon, SlotEip1967Admin, SlotEip1822Proxiable,
+	SlotOpenZeppelinImplementation, SlotOpenZeppelinAdmin, {}}
+
+// Maximum number of calls per JSON-RPC batch request (providers limit the batch size)
+const rpcBatchSize = 100
+
+// batchGetCodeAndSlots gets the code of all addresses, and their proxy storage slots, with JSON-RPC batch requests.
+// Codes and slots which could not be fetched are nil.
+func batchGetCodeAndSlots(ctx context.Context, caller ethrpc.RPCCaller, addresses []string, withSlots bool) (codes [][]byte, slots []map[common.Hash][]byte) {
+	codes = make([][]byte, len(addresses))
+	slots = make([]map[common.Hash][]byte, len(addresses))
+
+	// The calls of an address are always in the same batch
+	callsPerAddress := 1
+	if withSlots {
+		callsPerAddress += len(proxySlots)
+	}
+	addressesPerBatch := rpcBatchSize / callsPerAddress
+
+	for start := 0; start < len(addresses); start += addressesPerBatch {
+		end := start + addressesPerBatch
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+
+		results := make([]hexutil.Bytes, (end-start)*callsPerAddress)
+		batch := make([]rpc.BatchElem, 0, len(results))
+		for _, address := range addresses[start:end] {
+			addr := common.HexToAddress(address)
+			batch = append(batch, rpc.BatchElem{Method: "eth_getCode", Args: []interface{}{addr, "latest"}, Result: &results[len(batch)]})
+			if withSlots {
+				for _, slot := range proxySlots {
+					batch = append(batch, rpc.BatchElem{Method: "eth_getStorageAt", Args: []interface{}{addr, slot, "latest"}, Result: &results[len(batch)]})
+				}
+			}
+		}
+		if err := caller.BatchCallContext(ctx, batch); err != nil {
+			continue // fetched one by one
+		}
+
+		for i := start; i < end; i++ {
+			elems := batch[(i-start)*callsPerAddress : (i-start+1)*callsPerAddress]
+			if elems[0].Error == nil {
+				codes[i] = []byte(*elems[0].Result.(*hexutil.Bytes))
+				if codes[i] == nil {
+					codes[i] = []byte{}
+				}
+			}
+			if !withSlots {
+				continue
+			}
+			slots[i] = make(map[common.Hash][]byte)
+			for j, slot := range proxySlots {
+				if elems[j+1].Error != nil {
+					slots[i] = nil // read one by one
+					break
+				}
+				slots[i][slot] = *elems[j+1].Result.(*hexutil.Bytes)
+			}
+		}
+	}
+	return codes, slots
+}
+
+// prefetchedProxySource provides the proxy storage slots and masterCopy() result fetched in batches. Only the
+// implementation of beacons is called on demand.
+type prefetchedProxySource struct {
+	slots  map[common.Hash][]byte
+	master common.Address
+	client ethrpc.ContractCaller
+}
+
+func (s *prefetchedProxySource) storageAddress(slot common.Hash) (common.Address, error) {
+	value, found := s.slots[slot]
+	if !found {
+		return common.Address{}, fmt.Errorf("storage slot %s not fetched", slot.Hex())
+	}
+	return wordToAddress(value), nil
+}
+
+func (s *prefetchedProxySource) masterCopy() (common.Address, error) {
+	return s.master, nil
+}
+
+func (s *prefetchedProxySource) beaconImplementation(beacon common.Address) (common.Address, error) {
+	return callAddress(context.Background(), s.client, beacon, selectorImplementation)
+}
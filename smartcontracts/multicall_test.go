@@ -0,0 +1,180 @@
+package smartcontracts_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/go-ethutils/rpctest"
+	"github.com/metachris/go-ethutils/smartcontracts"
+)
+
+const aggregate3ABI = `[{"inputs":[{"components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}],"name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}],"name":"returnData","type":"tuple[]"}],"type":"function"}]`
+
+// multicallClient emulates the Multicall3 contract: it executes the calls of aggregate3 one by one with the fixtures,
+// so the fixtures don't depend on how the calls are batched
+type multicallClient struct {
+	*ethclient.Client
+	revertTarget   string // aggregate3 reverts if it contains a call of this address
+	aggregateCalls int64
+	otherCalls     int64
+}
+
+func (c *multicallClient) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if msg.To == nil || *msg.To != smartcontracts.Multicall3Address {
+		atomic.AddInt64(&c.otherCalls, 1)
+		return c.Client.CallContract(ctx, msg, blockNumber)
+	}
+	atomic.AddInt64(&c.aggregateCalls, 1)
+
+	multicallAbi, err := abi.JSON(strings.NewReader(aggregate3ABI))
+	if err != nil {
+		return nil, err
+	}
+	values, err := multicallAbi.Methods["aggregate3"].Inputs.Unpack(msg.Data[4:])
+	if err != nil {
+		return nil, err
+	}
+	type call3 struct {
+		Target       [20]byte
+		AllowFailure bool
+		CallData     []byte
+	}
+	type result struct {
+		Success    bool
+		ReturnData []byte
+	}
+	calls := *abi.ConvertType(values[0], new([]call3)).(*[]call3)
+	for _, call := range calls {
+		if c.revertTarget != "" && common.Address(call.Target) == common.HexToAddress(c.revertTarget) {
+			return nil, errors.New("execution reverted: out of gas")
+		}
+	}
+
+	results := make([]result, len(calls))
+	for i, call := range calls {
+		to := common.Address(call.Target)
+		output, err := c.Client.CallContract(ctx, ethereum.CallMsg{To: &to, Data: call.CallData}, blockNumber)
+		results[i] = result{Success: err == nil, ReturnData: output}
+	}
+	return multicallAbi.Methods["aggregate3"].Outputs.Pack(results)
+}
+
+// batchRecorder records the sizes of JSON-RPC batch requests, and optionally fails them
+type batchRecorder struct {
+	*rpc.Client
+	fail  bool
+	sizes []int
+}
+
+func (r *batchRecorder) BatchCallContext(ctx context.Context, b []rpc.BatchElem) error {
+	r.sizes = append(r.sizes, len(b))
+	if r.fail {
+		return errors.New("batch too large")
+	}
+	return r.Client.BatchCallContext(ctx, b)
+}
+
+func TestGetAddressDetailsFromBlockchain(t *testing.T) {
+	fixtures, err := rpctest.LoadFixtures(contractFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	defer server.Close()
+	ethClient, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ethClient.Close()
+	rpcClient, err := rpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+
+	addresses := []string{tokenAddress, nftAddress, erc1155Address, erc165Address, contractAddress, eoaAddress, eip1967Address, eip1167Address, beaconProxyAddress, safeAddress}
+	twice := append(append([]string{}, addresses...), addresses...)
+	largeBatches := &batchRecorder{Client: rpcClient}
+	failingBatches := &batchRecorder{Client: rpcClient, fail: true}
+
+	// With and without JSON-RPC batches for code and storage, the results are the same as one by one
+	tests := []struct {
+		name           string
+		addresses      []string
+		opts           smartcontracts.BatchOptions
+		aggregateCalls int64
+		otherCalls     int64 // -1 to skip the check
+	}{
+		{"batched", addresses, smartcontracts.BatchOptions{AddressesPerCall: 4, RPC: rpcClient, DetectProxies: true}, 3, 1}, // the beacon's implementation()
+		{"one by one", addresses, smartcontracts.BatchOptions{DetectProxies: true}, 1, -1},
+		{"large batches", twice, smartcontracts.BatchOptions{RPC: largeBatches, DetectProxies: true}, 1, 2},
+		{"failing batches", addresses, smartcontracts.BatchOptions{RPC: failingBatches, DetectProxies: true}, 1, -1},
+		{"without proxies", addresses, smartcontracts.BatchOptions{AddressesPerCall: 4, RPC: rpcClient}, 3, 0},
+	}
+	for _, tt := range tests {
+		client := &multicallClient{Client: ethClient}
+		details := smartcontracts.GetAddressDetailsFromBlockchain(tt.addresses, client, tt.opts)
+		if len(details) != len(tt.addresses) {
+			t.Fatalf("%s: got %d details for %d addresses", tt.name, len(details), len(tt.addresses))
+		}
+		for i, address := range tt.addresses {
+			expected, _ := smartcontracts.GetAddressDetailFromBlockchain(address, ethClient)
+			if tt.opts.DetectProxies {
+				expected, _ = smartcontracts.GetAddressDetailWithProxy(address, ethClient)
+			}
+			if details[i] != expected {
+				t.Errorf("%s: %s: got %+v, expected %+v", tt.name, address, details[i], expected)
+			}
+		}
+
+		if client.aggregateCalls != tt.aggregateCalls {
+			t.Errorf("%s: %d aggregate3 calls, expected %d", tt.name, client.aggregateCalls, tt.aggregateCalls)
+		}
+		if tt.otherCalls >= 0 && client.otherCalls != tt.otherCalls {
+			t.Errorf("%s: %d other eth_calls, expected %d", tt.name, client.otherCalls, tt.otherCalls)
+		}
+	}
+
+	// 20 addresses with 8 calls each are split into batches of at most 100 calls, without splitting the calls of an
+	// address
+	if !reflect.DeepEqual(largeBatches.sizes, []int{96, 64}) {
+		t.Errorf("got batch sizes %v, expected [96 64]", largeBatches.sizes)
+	}
+}
+
+func TestGetAddressDetailsFromBlockchainChunkError(t *testing.T) {
+	client := &multicallClient{Client: newContractClient(t), revertTarget: contractAddress}
+	addresses := []string{tokenAddress, nftAddress, erc1155Address, erc165Address, contractAddress, eoaAddress, eip1967Address, eip1167Address, beaconProxyAddress, safeAddress}
+
+	// The aggregate3 call of the second chunk fails, its addresses are classified one by one
+	var failed []string
+	opts := smartcontracts.BatchOptions{AddressesPerCall: 4, DetectProxies: true, OnChunkError: func(addresses []string, err error) {
+		failed = append(failed, addresses...)
+	}}
+	details := smartcontracts.GetAddressDetailsFromBlockchain(addresses, client, opts)
+	if !reflect.DeepEqual(failed, addresses[4:8]) {
+		t.Errorf("got failed addresses %v, expected %v", failed, addresses[4:8])
+	}
+	if len(details) != len(addresses) {
+		t.Fatalf("got %d details for %d addresses", len(details), len(addresses))
+	}
+	for i, address := range addresses {
+		expected, _ := smartcontracts.GetAddressDetailWithProxy(address, client.Client)
+		if details[i] != expected {
+			t.Errorf("%s: got %+v, expected %+v", address, details[i], expected)
+		}
+	}
+	if client.aggregateCalls != 3 {
+		t.Errorf("%d aggregate3 calls, expected 3", client.aggregateCalls)
+	}
+}
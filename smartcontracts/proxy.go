@@ -0,0 +1,180 @@
+package smartcontracts
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// Storage slots of the proxy standards
+var (
+	SlotEip1967Implementation      = eip1967Slot("eip1967.proxy.implementation") // 0x360894a1...
+	SlotEip1967Beacon              = eip1967Slot("eip1967.proxy.beacon")         // 0xa3f0ad74...
+	SlotEip1967Admin               = eip1967Slot("eip1967.proxy.admin")          // 0xb5312768...
+	SlotEip1822Proxiable           = crypto.Keccak256Hash([]byte("PROXIABLE"))
+	SlotOpenZeppelinImplementation = crypto.Keccak256Hash([]byte("org.zeppelinos.proxy.implementation"))
+	SlotOpenZeppelinAdmin          = crypto.Keccak256Hash([]byte("org.zeppelinos.proxy.admin"))
+)
+
+// eip1967Slot returns keccak256(name) - 1, which has no known preimage
+func eip1967Slot(name string) common.Hash {
+	slot := new(big.Int).SetBytes(crypto.Keccak256([]byte(name)))
+	return common.BigToHash(slot.Sub(slot, big.NewInt(1)))
+}
+
+var (
+	// Runtime bytecode of EIP-1167 minimal proxies, with the implementation address between prefix and suffix
+	eip1167Prefix = common.FromHex("0x363d3d373d3d3d363d73")
+	eip1167Suffix = common.FromHex("0x5af43d82803e903d91602b57fd5bf3")
+
+	selectorImplementation = common.FromHex("0x5c60da1b") // implementation(), of EIP-1967 beacons
+	selectorMasterCopy     = common.FromHex("0xa619486e") // masterCopy(), answered by Gnosis Safe proxies
+)
+
+// ProxyInfo describes a proxy contract
+type ProxyInfo struct {
+	Type           addressdetail.ProxyType
+	Implementation common.Address
+	Beacon         common.Address // only for ProxyTypeEip1967Beacon
+	Admin          common.Address // if set in the EIP-1967 or ZeppelinOS admin slot
+}
+
+// ParseEip1167 returns the implementation address if code is the runtime bytecode of an EIP-1167 minimal proxy
+func ParseEip1167(code []byte) (implementation common.Address, isEip1167 bool) {
+	if len(code) != len(eip1167Prefix)+common.AddressLength+len(eip1167Suffix) || !bytes.HasPrefix(code, eip1167Prefix) || !bytes.HasSuffix(code, eip1167Suffix) {
+		return implementation, false
+	}
+	return common.BytesToAddress(code[len(eip1167Prefix) : len(eip1167Prefix)+common.AddressLength]), true
+}
+
+// DetectProxy checks whether a contract is a proxy, and returns the implementation address and proxy type. It
+// detects EIP-1167 minimal proxies from the bytecode, EIP-1967 (implementation or beacon), EIP-1822 and ZeppelinOS
+// proxies from their storage slots, and Gnosis Safe proxies with masterCopy().
+func DetectProxy(address string, client ethrpc.ContractStorageReader) (proxy ProxyInfo, isProxy bool, err error) {
+	ctx := context.Background()
+	addr := common.HexToAddress(address)
+
+	code, err := client.CodeAt(ctx, addr, nil)
+	if err != nil || len(code) == 0 {
+		return proxy, false, err
+	}
+	return detectProxy(code, &clientProxySource{ctx: ctx, client: client, address: addr})
+}
+
+// proxySource provides the contract state for proxy detection
+type proxySource interface {
+	storageAddress(slot common.Hash) (common.Address, error) // the address in a storage slot of the contract
+	masterCopy() (common.Address, error)                     // result of masterCopy(), zero address if it reverted
+	beaconImplementation(beacon common.Address) (common.Address, error)
+}
+
+// clientProxySource reads the state on demand, so that only the slots up to the first match are read
+type clientProxySource struct {
+	ctx     context.Context
+	client  ethrpc.ContractStorageReader
+	address common.Address
+}
+
+func (s *clientProxySource) storageAddress(slot common.Hash) (common.Address, error) {
+	value, err := s.client.StorageAt(s.ctx, s.address, slot, nil)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return wordToAddress(value), nil
+}
+
+func (s *clientProxySource) masterCopy() (common.Address, error) {
+	masterCopy, err := callAddress(s.ctx, s.client, s.address, selectorMasterCopy)
+	if err != nil && !ethrpc.IsTransientError(err) {
+		return common.Address{}, nil // reverted, not a Gnosis Safe
+	}
+	return masterCopy, err
+}
+
+func (s *clientProxySource) beaconImplementation(beacon common.Address) (common.Address, error) {
+	return callAddress(s.ctx, s.client, beacon, selectorImplementation)
+}
+
+func detectProxy(code []byte, src proxySource) (proxy ProxyInfo, isProxy bool, err error) {
+	if implementation, isEip1167 := ParseEip1167(code); isEip1167 {
+		return ProxyInfo{Type: addressdetail.ProxyTypeEip1167, Implementation: implementation}, true, nil
+	}
+
+	// EIP-1967 implementation slot
+	if proxy.Implementation, err = src.storageAddress(SlotEip1967Implementation); err != nil {
+		return proxy, false, err
+	}
+	if proxy.Implementation != (common.Address{}) {
+		proxy.Type = addressdetail.ProxyTypeEip1967
+		proxy.Admin, err = src.storageAddress(SlotEip1967Admin)
+		return proxy, err == nil, err
+	}
+
+	// EIP-1967 beacon slot, the beacon knows the implementation
+	if proxy.Beacon, err = src.storageAddress(SlotEip1967Beacon); err != nil {
+		return proxy, false, err
+	}
+	if proxy.Beacon != (common.Address{}) {
+		proxy.Type = addressdetail.ProxyTypeEip1967Beacon
+		if proxy.Implementation, err = src.beaconImplementation(proxy.Beacon); err != nil {
+			return proxy, false, err
+		}
+		proxy.Admin, err = src.storageAddress(SlotEip1967Admin)
+		return proxy, err == nil, err
+	}
+
+	// EIP-1822
+	if proxy.Implementation, err = src.storageAddress(SlotEip1822Proxiable); err != nil {
+		return proxy, false, err
+	}
+	if proxy.Implementation != (common.Address{}) {
+		proxy.Type = addressdetail.ProxyTypeEip1822
+		return proxy, true, nil
+	}
+
+	// ZeppelinOS / OpenZeppelin SDK before EIP-1967
+	if proxy.Implementation, err = src.storageAddress(SlotOpenZeppelinImplementation); err != nil {
+		return proxy, false, err
+	}
+	if proxy.Implementation != (common.Address{}) {
+		proxy.Type = addressdetail.ProxyTypeOpenZeppelinLegacy
+		proxy.Admin, err = src.storageAddress(SlotOpenZeppelinAdmin)
+		return proxy, err == nil, err
+	}
+
+	// Gnosis Safe: the master copy is in slot 0, and the proxy answers masterCopy() with it. Checking both avoids false
+	// positives, since many contracts have something in slot 0.
+	masterCopy, err := src.masterCopy()
+	if err != nil || masterCopy == (common.Address{}) {
+		return ProxyInfo{}, false, err
+	}
+	slot0, err := src.storageAddress(common.Hash{})
+	if err != nil || slot0 != masterCopy {
+		return ProxyInfo{}, false, err
+	}
+	return ProxyInfo{Type: addressdetail.ProxyTypeGnosisSafe, Implementation: masterCopy}, true, nil
+}
+
+// callAddress calls a method without arguments which returns an address
+func callAddress(ctx context.Context, client ethrpc.ContractCaller, to common.Address, selector []byte) (common.Address, error) {
+	result, err := client.CallContract(ctx, ethereum.CallMsg{To: &to, Data: selector}, nil)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return wordToAddress(result), nil
+}
+
+// wordToAddress returns the address in a 32-byte word, or the zero address if the word isn't an address (upper 12
+// bytes not zero)
+func wordToAddress(word []byte) common.Address {
+	if len(word) != 32 || !bytes.Equal(word[:12], make([]byte, 12)) {
+		return common.Address{}
+	}
+	return common.BytesToAddress(word[12:])
+}
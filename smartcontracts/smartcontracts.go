@@ -3,70 +3,113 @@ package smartcontracts
 
 import (
 	"context"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/eth-go-bindings/erc1155"
 	"github.com/metachris/eth-go-bindings/erc165"
 	"github.com/metachris/eth-go-bindings/erc20"
 	"github.com/metachris/eth-go-bindings/erc721"
 	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/ethrpc"
 )
 
-func IsContract(address string, client *ethclient.Client) (isContract bool, err error) {
+func IsContract(address string, client ethrpc.CodeReader) (isContract bool, err error) {
 	addr := common.HexToAddress(address)
 	b, err := client.CodeAt(context.Background(), addr, nil)
 	return len(b) > 0, err
 }
 
-func SmartContractSupportsInterface(address string, interfaceId [4]byte, client *ethclient.Client) (supportsInterface bool, err error) {
+// SmartContractSupportsInterface calls supportsInterface(interfaceId) of the contract (ERC165). Note that contracts
+// which don't implement ERC165 properly may answer true to anything, see IsErc165.
+func SmartContractSupportsInterface(address string, interfaceId [4]byte, client ethrpc.ContractReader) (supportsInterface bool, err error) {
 	addr := common.HexToAddress(address)
-	instance, err := erc165.NewErc165(addr, client) // the SupportsInterface signature is the same for all contract types, so we can just use the ERC721 interface
+	instance, err := erc165.NewErc165Caller(addr, client)
 	if err != nil {
 		return supportsInterface, err
 	}
-	supportsInterface, err = instance.SupportsInterface(nil, erc165.InterfaceIdErc721)
+	supportsInterface, err = instance.SupportsInterface(nil, interfaceId)
 	return supportsInterface, err
 }
 
-// TODO: Currently returns true for every SC that supports INTERFACEID_ERC165. It should really be INTERFACEID_ERC721,
-// but that doesn't detect some SCs, eg. cryptokitties https://etherscan.io/address/0x06012c8cf97BEaD5deAe237070F9587f8E7A266d#readContract
-// As a quick fix, just checks ERC165 and count it as ERC721 address. Improve with further/better SC method checks.
-func IsErc721(address string, client *ethclient.Client) (isErc721 bool, detail addressdetail.AddressDetail, err error) {
+// IsErc721 checks whether the contract implements ERC165 and supports the ERC721 interface (or the draft ERC721
+// interface of early NFTs like CryptoKitties), and gets name and symbol if available.
+func IsErc721(address string, client ethrpc.ContractReader) (isErc721 bool, detail addressdetail.AddressDetail, err error) {
 	detail.Address = address
-
-	addr := common.HexToAddress(address)
-	instance, err := erc721.NewErc721(addr, client)
-	if err != nil {
+	isErc165, err := IsErc165(address, client)
+	if err != nil || !isErc165 {
 		return false, detail, err
 	}
+	return isErc165Erc721(address, client)
+}
 
-	isErc721, err = instance.SupportsInterface(nil, erc165.InterfaceIdErc165)
+// isErc165Erc721 is IsErc721 for a contract which is known to implement ERC165
+func isErc165Erc721(address string, client ethrpc.ContractReader) (isErc721 bool, detail addressdetail.AddressDetail, err error) {
+	detail.Address = address
+	isErc721, err = supportsInterface(address, erc165.InterfaceIdErc721, client)
+	if err == nil && !isErc721 {
+		isErc721, err = supportsInterface(address, InterfaceIdErc721Legacy, client)
+	}
 	if err != nil || !isErc721 {
-		return isErc721, detail, err
+		return false, detail, err
 	}
 
-	// It appears to be ERC721
+	// It's ERC721
 	detail.Type = addressdetail.AddressTypeErc721
 
-	// Try to get a name and symbol
-	detail.Name, _ = instance.Name(nil)
-	// if err != nil {
-	// 	// eg. "abi: cannot marshal in to go slice: offset 33 would go over slice boundary (len=32)"
-	// 	// ignore, since we don't check erc721 metadata extension
-	// }
+	// Try to get a name and symbol (optional metadata extension)
+	detail.Name, detail.Symbol = getNameAndSymbol(address, client)
+	return true, detail, nil
+}
 
-	detail.Symbol, _ = instance.Symbol(nil)
-	// if err != nil {
-	// 	// ignore, since we don't check erc721 metadata extension
-	// }
+// IsErc1155 checks whether the contract implements ERC165 and supports the ERC1155 interface. It gets the metadata URI
+// template with uri(0), and name and symbol if available (not part of the standard, but many collections have them).
+func IsErc1155(address string, client ethrpc.ContractReader) (isErc1155 bool, detail addressdetail.AddressDetail, err error) {
+	detail.Address = address
+	isErc165, err := IsErc165(address, client)
+	if err != nil || !isErc165 {
+		return false, detail, err
+	}
+	return isErc165Erc1155(address, client)
+}
 
+// isErc165Erc1155 is IsErc1155 for a contract which is known to implement ERC165
+func isErc165Erc1155(address string, client ethrpc.ContractReader) (isErc1155 bool, detail addressdetail.AddressDetail, err error) {
+	detail.Address = address
+	isErc1155, err = supportsInterface(address, erc165.InterfaceIdErc1155, client)
+	if err != nil || !isErc1155 {
+		return false, detail, err
+	}
+
+	// It's ERC1155
+	detail.Type = addressdetail.AddressTypeErc1155
+
+	// The URI is the same template for all token IDs (the client replaces "{id}"), so any ID works
+	addr := common.HexToAddress(address)
+	if instance, err := erc1155.NewErc1155Caller(addr, client); err == nil {
+		detail.MetadataURI, _ = instance.Uri(nil, big.NewInt(0))
+	}
+	detail.Name, detail.Symbol = getNameAndSymbol(address, client)
 	return true, detail, nil
 }
 
-func IsErc20(address string, client *ethclient.Client) (isErc20 bool, detail addressdetail.AddressDetail, err error) {
+// getNameAndSymbol calls the optional name() and symbol() methods of NFT contracts, empty strings if they fail
+func getNameAndSymbol(address string, client ethrpc.ContractReader) (name string, symbol string) {
+	addr := common.HexToAddress(address)
+	instance, err := erc721.NewErc721Caller(addr, client) // name() and symbol() are the same for ERC721 and ERC1155
+	if err != nil {
+		return "", ""
+	}
+	// Errors are ignored, eg. "abi: cannot marshal in to go slice: offset 33 would go over slice boundary (len=32)"
+	name, _ = instance.Name(nil)
+	symbol, _ = instance.Symbol(nil)
+	return name, symbol
+}
+
+func IsErc20(address string, client ethrpc.ContractReader) (isErc20 bool, detail addressdetail.AddressDetail, err error) {
 	detail.Address = address
 	addr := common.HexToAddress(address)
-	instance, err := erc20.NewErc20(addr, client)
+	instance, err := erc20.NewErc20Caller(addr, client)
 	if err != nil {
 		return false, detail, err
 	}
@@ -98,14 +141,34 @@ func IsErc20(address string, client *ethclient.Client) (isErc20 bool, detail add
 	return true, detail, nil
 }
 
-// GetAddressDetailFromBlockchain tries to detect an ERC20 / ERC721 token or generic smart contract, and returns an addressdetail.AddressDetail
+// GetAddressDetailWithProxy is like GetAddressDetailFromBlockchain, and also detects proxy contracts and their
+// implementation (see DetectProxy). This costs up to 6 more eth_getStorageAt calls and an eth_call per contract.
+func GetAddressDetailWithProxy(address string, client ethrpc.ContractStorageReader) (detail addressdetail.AddressDetail, found bool) {
+	detail, found = GetAddressDetailFromBlockchain(address, client)
+	if !found {
+		return detail, found
+	}
+
+	if proxy, isProxy, _ := DetectProxy(address, client); isProxy {
+		detail.ProxyType = proxy.Type
+		detail.Implementation = proxy.Implementation.Hex()
+	}
+	return detail, found
+}
+
+// GetAddressDetailFromBlockchain tries to detect an ERC20 / ERC721 / ERC1155 token or generic smart contract, and returns an addressdetail.AddressDetail
 // with the received details.
-func GetAddressDetailFromBlockchain(address string, client *ethclient.Client) (detail addressdetail.AddressDetail, found bool) {
+func GetAddressDetailFromBlockchain(address string, client ethrpc.ContractReader) (detail addressdetail.AddressDetail, found bool) {
 	detail = addressdetail.NewAddressDetail(address)
 
-	// check for erc721
-	if isErc721, detail, _ := IsErc721(address, client); isErc721 {
-		return detail, true
+	// check for erc721 and erc1155 (both use erc165)
+	if isErc165, _ := IsErc165(address, client); isErc165 {
+		if isErc721, detail, _ := isErc165Erc721(address, client); isErc721 {
+			return detail, true
+		}
+		if isErc1155, detail, _ := isErc165Erc1155(address, client); isErc1155 {
+			return detail, true
+		}
 	}
 
 	// check for erc20
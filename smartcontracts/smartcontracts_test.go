@@ -0,0 +1,160 @@
+package smartcontracts_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/rpctest"
+	"github.com/metachris/go-ethutils/smartcontracts"
+)
+
+const (
+	contractFixtures = "../rpctest/testdata/contracts.json"
+
+	tokenAddress    = "0x00000000000000000000000000000000000e2c20" // ERC20 "Test Token"
+	nftAddress      = "0x0000000000000000000000000000000000e2c721" // ERC721 "Test NFT"
+	erc1155Address  = "0x000000000000000000000000000000000e211550" // ERC1155 "Test Items"
+	erc165Address   = "0x00000000000000000000000000000000000e2165" // ERC165, but not an NFT
+	contractAddress = "0x00000000000000000000000000000000c0ffee00" // other contract
+	eoaAddress      = "0x0000000000000000000000000000000000000e0a"
+
+	eip1967Address     = "0x00000000000000000000000000000000000e1967" // EIP-1967 proxy of an ERC20 token
+	eip1167Address     = "0x00000000000000000000000000000000000e1167" // minimal proxy of nftAddress
+	beaconProxyAddress = "0x00000000000000000000000000000000000beac0" // beacon proxy of contractAddress
+	safeAddress        = "0x0000000000000000000000000000000000005afe" // Gnosis Safe proxy
+
+	// With the runtime bytecode of real compiled contracts
+	compiledTokenAddress  = "0x00000000000000000000000000000000000bc020" // OpenZeppelin ERC20 "Compiled Token"
+	compiledNftAddress    = "0x0000000000000000000000000000000000bc0721" // OpenZeppelin ERC721 "Compiled NFT"
+	compiledItemsAddress  = "0x000000000000000000000000000000000bc01155" // OpenZeppelin ERC1155
+	compiledOracleAddress = "0x000000000000000000000000000000000bc0c0de" // go-ethereum's checkpoint oracle, not a token
+)
+
+func checksum(address string) string {
+	return common.HexToAddress(address).Hex()
+}
+
+func newContractClient(t *testing.T) *ethclient.Client {
+	fixtures, err := rpctest.LoadFixtures(contractFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	t.Cleanup(server.Close)
+
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestGetAddressDetailFromBlockchain(t *testing.T) {
+	client := newContractClient(t)
+
+	tests := []struct {
+		address string
+		found   bool
+		detail  addressdetail.AddressDetail
+	}{
+		{tokenAddress, true, addressdetail.AddressDetail{Address: tokenAddress, Type: addressdetail.AddressTypeErc20, Name: "Test Token", Symbol: "TST", Decimals: 18}},
+		{nftAddress, true, addressdetail.AddressDetail{Address: nftAddress, Type: addressdetail.AddressTypeErc721, Name: "Test NFT", Symbol: "TNFT"}},
+		{erc1155Address, true, addressdetail.AddressDetail{Address: erc1155Address, Type: addressdetail.AddressTypeErc1155, Name: "Test Items", MetadataURI: "https://items.example.com/api/{id}.json"}},
+		{erc165Address, true, addressdetail.AddressDetail{Address: erc165Address, Type: addressdetail.AddressTypeOtherContract}},
+		{contractAddress, true, addressdetail.AddressDetail{Address: contractAddress, Type: addressdetail.AddressTypeOtherContract}},
+		{eoaAddress, false, addressdetail.AddressDetail{Address: eoaAddress, Type: addressdetail.AddressTypeEOA}},
+		{eip1967Address, true, addressdetail.AddressDetail{Address: eip1967Address, Type: addressdetail.AddressTypeErc20, Name: "Proxy Token", Symbol: "PTK", Decimals: 6,
+			ProxyType: addressdetail.ProxyTypeEip1967, Implementation: checksum(tokenAddress)}},
+		{eip1167Address, true, addressdetail.AddressDetail{Address: eip1167Address, Type: addressdetail.AddressTypeOtherContract, ProxyType: addressdetail.ProxyTypeEip1167, Implementation: checksum(nftAddress)}},
+	}
+
+	for _, tt := range tests {
+		// Proxies are only resolved on request
+		detail, found := smartcontracts.GetAddressDetailWithProxy(tt.address, client)
+		if found != tt.found || detail != tt.detail {
+			t.Errorf("%s: got %v %+v, expected %v %+v", tt.address, found, detail, tt.found, tt.detail)
+		}
+
+		tt.detail.ProxyType, tt.detail.Implementation = addressdetail.ProxyTypeNone, ""
+		detail, found = smartcontracts.GetAddressDetailFromBlockchain(tt.address, client)
+		if found != tt.found || detail != tt.detail {
+			t.Errorf("%s: got %v %+v, expected %v %+v", tt.address, found, detail, tt.found, tt.detail)
+		}
+	}
+}
+
+func TestIsContract(t *testing.T) {
+	client := newContractClient(t)
+
+	if isContract, err := smartcontracts.IsContract(contractAddress, client); err != nil || !isContract {
+		t.Errorf("expected contract, got %v %v", isContract, err)
+	}
+	if isContract, err := smartcontracts.IsContract(eoaAddress, client); err != nil || isContract {
+		t.Errorf("expected no contract, got %v %v", isContract, err)
+	}
+}
+
+func TestGetSupportedInterfaces(t *testing.T) {
+	client := newContractClient(t)
+
+	isErc165, supported, err := smartcontracts.GetSupportedInterfaces(nftAddress, client)
+	if err != nil || !isErc165 {
+		t.Fatalf("expected ERC165 contract, got %v %v", isErc165, err)
+	}
+	names := make([]string, len(supported))
+	for i, iface := range supported {
+		names[i] = iface.Name
+	}
+	if strings.Join(names, ",") != "ERC721,ERC721Metadata,ERC2981" {
+		t.Errorf("unexpected interfaces %v", names)
+	}
+
+	isErc165, supported, err = smartcontracts.GetSupportedInterfaces(erc165Address, client)
+	if err != nil || !isErc165 || len(supported) != 0 {
+		t.Errorf("expected ERC165 contract without interfaces, got %v %v %v", isErc165, supported, err)
+	}
+
+	// Contracts without supportsInterface
+	for _, address := range []string{tokenAddress, contractAddress} {
+		if isErc165, _, err := smartcontracts.GetSupportedInterfaces(address, client); err != nil || isErc165 {
+			t.Errorf("%s: expected no ERC165 contract, got %v %v", address, isErc165, err)
+		}
+	}
+}
+
+func TestDetectProxy(t *testing.T) {
+	client := newContractClient(t)
+
+	if slot := smartcontracts.SlotEip1967Implementation.Hex(); slot != "0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc" {
+		t.Errorf("wrong EIP-1967 implementation slot %s", slot)
+	}
+
+	tests := []struct {
+		address        string
+		proxyType      addressdetail.ProxyType
+		implementation string
+	}{
+		{eip1967Address, addressdetail.ProxyTypeEip1967, tokenAddress},
+		{eip1167Address, addressdetail.ProxyTypeEip1167, nftAddress},
+		{beaconProxyAddress, addressdetail.ProxyTypeEip1967Beacon, contractAddress},
+		{safeAddress, addressdetail.ProxyTypeGnosisSafe, "0x00000000000000000000000000000000005afe10"},
+		{tokenAddress, addressdetail.ProxyTypeNone, ""},
+		{contractAddress, addressdetail.ProxyTypeNone, ""},
+		{eoaAddress, addressdetail.ProxyTypeNone, ""},
+	}
+
+	for _, tt := range tests {
+		proxy, isProxy, err := smartcontracts.DetectProxy(tt.address, client)
+		if err != nil {
+			t.Errorf("%s: %v", tt.address, err)
+			continue
+		}
+		if isProxy != (tt.proxyType != addressdetail.ProxyTypeNone) || proxy.Type != tt.proxyType || (isProxy && proxy.Implementation != common.HexToAddress(tt.implementation)) {
+			t.Errorf("%s: got %v %+v, expected %s with implementation %s", tt.address, isProxy, proxy, tt.proxyType, tt.implementation)
+		}
+	}
+}
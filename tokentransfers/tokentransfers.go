@@ -0,0 +1,187 @@
+// Extract ERC20, ERC721 and ERC1155 token transfers from transaction receipts
+package tokentransfers
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/metachris/go-ethutils/addressdetail"
+	"github.com/metachris/go-ethutils/addresslookup"
+	"github.com/metachris/go-ethutils/blockswithtx"
+)
+
+type Standard string
+
+const (
+	StandardErc20   Standard = "erc20"
+	StandardErc721  Standard = "erc721"
+	StandardErc1155 Standard = "erc1155"
+)
+
+var (
+	TopicTransfer       = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+	TopicTransferSingle = crypto.Keccak256Hash([]byte("TransferSingle(address,address,address,uint256,uint256)"))
+	TopicTransferBatch  = crypto.Keccak256Hash([]byte("TransferBatch(address,address,address,uint256[],uint256[])"))
+)
+
+// Transfer is a single token transfer. Mints have From set to the zero address, burns have To set to it.
+type Transfer struct {
+	Standard Standard
+	Token    common.Address
+	From     common.Address
+	To       common.Address
+	Amount   *big.Int       // number of tokens (always 1 for ERC721)
+	TokenID  *big.Int       // nil for ERC20
+	Operator common.Address // ERC1155 only
+
+	BlockNumber uint64
+	TxHash      common.Hash
+	TxIndex     uint
+	LogIndex    uint
+
+	// TokenDetail has the name, symbol and decimals of the token, if set with Enrich
+	TokenDetail *addressdetail.AddressDetail
+}
+
+func (t *Transfer) String() string {
+	token := t.Token.Hex()
+	if t.TokenDetail != nil && t.TokenDetail.Symbol != "" {
+		token = t.TokenDetail.Symbol
+	}
+	if t.TokenID != nil {
+		return fmt.Sprintf("%s %s #%s x%s: %s -> %s", t.Standard, token, t.TokenID, t.Amount, t.From.Hex(), t.To.Hex())
+	}
+	return fmt.Sprintf("%s %s %s: %s -> %s", t.Standard, token, t.HumanAmount(), t.From.Hex(), t.To.Hex())
+}
+
+// HumanAmount returns the amount with the token decimals applied (if known from Enrich), eg. "1.5" instead of
+// "1500000000000000000"
+func (t *Transfer) HumanAmount() string {
+	if t.TokenDetail == nil || t.TokenDetail.Decimals == 0 || t.Standard != StandardErc20 {
+		return t.Amount.String()
+	}
+
+	divisor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(t.TokenDetail.Decimals)), nil)
+	intPart, fracPart := new(big.Int).QuoRem(t.Amount, divisor, new(big.Int))
+	if fracPart.Sign() == 0 {
+		return intPart.String()
+	}
+
+	frac := fmt.Sprintf("%0*s", int(t.TokenDetail.Decimals), new(big.Int).Abs(fracPart).String())
+	for frac[len(frac)-1] == '0' {
+		frac = frac[:len(frac)-1]
+	}
+	return intPart.String() + "." + frac
+}
+
+var uint256ArrayArgs = func() abi.Arguments {
+	uint256Array, _ := abi.NewType("uint256[]", "", nil)
+	return abi.Arguments{{Type: uint256Array}, {Type: uint256Array}}
+}()
+
+// FromLog returns the transfers of a log (TransferBatch logs contain several), or nil if the log isn't a token
+// transfer. ERC20 and ERC721 use the same Transfer event, they are distinguished by the number of indexed
+// parameters: ERC20 has the amount in the data, ERC721 has the token ID as third topic.
+func FromLog(log *types.Log) []*Transfer {
+	if len(log.Topics) == 0 || log.Removed {
+		return nil
+	}
+
+	newTransfer := func(standard Standard) *Transfer {
+		return &Transfer{
+			Standard:    standard,
+			Token:       log.Address,
+			BlockNumber: log.BlockNumber,
+			TxHash:      log.TxHash,
+			TxIndex:     log.TxIndex,
+			LogIndex:    log.Index,
+		}
+	}
+
+	switch log.Topics[0] {
+	case TopicTransfer:
+		if len(log.Topics) == 3 && len(log.Data) == 32 {
+			t := newTransfer(StandardErc20)
+			t.From = common.BytesToAddress(log.Topics[1].Bytes())
+			t.To = common.BytesToAddress(log.Topics[2].Bytes())
+			t.Amount = new(big.Int).SetBytes(log.Data)
+			return []*Transfer{t}
+		}
+		if len(log.Topics) == 4 && len(log.Data) == 0 {
+			t := newTransfer(StandardErc721)
+			t.From = common.BytesToAddress(log.Topics[1].Bytes())
+			t.To = common.BytesToAddress(log.Topics[2].Bytes())
+			t.TokenID = log.Topics[3].Big()
+			t.Amount = big.NewInt(1)
+			return []*Transfer{t}
+		}
+
+	case TopicTransferSingle:
+		if len(log.Topics) == 4 && len(log.Data) == 64 {
+			t := newTransfer(StandardErc1155)
+			t.Operator = common.BytesToAddress(log.Topics[1].Bytes())
+			t.From = common.BytesToAddress(log.Topics[2].Bytes())
+			t.To = common.BytesToAddress(log.Topics[3].Bytes())
+			t.TokenID = new(big.Int).SetBytes(log.Data[:32])
+			t.Amount = new(big.Int).SetBytes(log.Data[32:])
+			return []*Transfer{t}
+		}
+
+	case TopicTransferBatch:
+		if len(log.Topics) != 4 {
+			return nil
+		}
+		values, err := uint256ArrayArgs.Unpack(log.Data)
+		if err != nil {
+			return nil
+		}
+		ids, amounts := values[0].([]*big.Int), values[1].([]*big.Int)
+		if len(ids) != len(amounts) {
+			return nil
+		}
+
+		transfers := make([]*Transfer, len(ids))
+		for i := range ids {
+			t := newTransfer(StandardErc1155)
+			t.Operator = common.BytesToAddress(log.Topics[1].Bytes())
+			t.From = common.BytesToAddress(log.Topics[2].Bytes())
+			t.To = common.BytesToAddress(log.Topics[3].Bytes())
+			t.TokenID = ids[i]
+			t.Amount = amounts[i]
+			transfers[i] = t
+		}
+		return transfers
+	}
+	return nil
+}
+
+// FromReceipt returns all token transfers of a transaction, in log order
+func FromReceipt(receipt *types.Receipt) (transfers []*Transfer) {
+	for _, log := range receipt.Logs {
+		transfers = append(transfers, FromLog(log)...)
+	}
+	return transfers
+}
+
+// FromBlock returns all token transfers of a block, in transaction and log order
+func FromBlock(b *blockswithtx.BlockWithTxReceipts) (transfers []*Transfer) {
+	for _, tx := range b.Block.Transactions() {
+		if receipt := b.TxReceipts[tx.Hash()]; receipt != nil {
+			transfers = append(transfers, FromReceipt(receipt)...)
+		}
+	}
+	return transfers
+}
+
+// Enrich sets the TokenDetail of all transfers (name, symbol and decimals), from the cache of the lookup service or
+// from the blockchain
+func Enrich(transfers []*Transfer, lookup *addresslookup.AddressLookupService) {
+	for _, t := range transfers {
+		detail, _ := lookup.GetAddressDetail(t.Token.Hex())
+		t.TokenDetail = &detail
+	}
+}
@@ -0,0 +1,143 @@
+package tokentransfers_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/go-ethutils/addresslookup"
+	"github.com/metachris/go-ethutils/blockswithtx"
+	"github.com/metachris/go-ethutils/rpctest"
+	"github.com/metachris/go-ethutils/tokentransfers"
+)
+
+var (
+	token    = common.HexToAddress("0x00000000000000000000000000000000000e2c20")
+	alice    = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	bob      = common.HexToAddress("0x2000000000000000000000000000000000000002")
+	operator = common.HexToAddress("0x3000000000000000000000000000000000000003")
+)
+
+func word(n int64) []byte {
+	return common.LeftPadBytes(big.NewInt(n).Bytes(), 32)
+}
+
+func addressTopic(a common.Address) common.Hash {
+	return common.BytesToHash(a.Bytes())
+}
+
+func concat(parts ...[]byte) (b []byte) {
+	for _, part := range parts {
+		b = append(b, part...)
+	}
+	return b
+}
+
+func TestFromLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		log      *types.Log
+		expected []tokentransfers.Transfer
+	}{
+		{
+			name:     "erc20",
+			log:      &types.Log{Topics: []common.Hash{tokentransfers.TopicTransfer, addressTopic(alice), addressTopic(bob)}, Data: word(500)},
+			expected: []tokentransfers.Transfer{{Standard: tokentransfers.StandardErc20, From: alice, To: bob, Amount: big.NewInt(500)}},
+		},
+		{
+			name:     "erc721",
+			log:      &types.Log{Topics: []common.Hash{tokentransfers.TopicTransfer, addressTopic(common.Address{}), addressTopic(bob), common.BigToHash(big.NewInt(42))}},
+			expected: []tokentransfers.Transfer{{Standard: tokentransfers.StandardErc721, To: bob, Amount: big.NewInt(1), TokenID: big.NewInt(42)}},
+		},
+		{
+			name:     "erc1155 single",
+			log:      &types.Log{Topics: []common.Hash{tokentransfers.TopicTransferSingle, addressTopic(operator), addressTopic(alice), addressTopic(bob)}, Data: concat(word(7), word(3))},
+			expected: []tokentransfers.Transfer{{Standard: tokentransfers.StandardErc1155, Operator: operator, From: alice, To: bob, Amount: big.NewInt(3), TokenID: big.NewInt(7)}},
+		},
+		{
+			name: "erc1155 batch",
+			log: &types.Log{
+				Topics: []common.Hash{tokentransfers.TopicTransferBatch, addressTopic(operator), addressTopic(alice), addressTopic(bob)},
+				Data:   concat(word(64), word(160), word(2), word(7), word(8), word(2), word(100), word(200)),
+			},
+			expected: []tokentransfers.Transfer{
+				{Standard: tokentransfers.StandardErc1155, Operator: operator, From: alice, To: bob, Amount: big.NewInt(100), TokenID: big.NewInt(7)},
+				{Standard: tokentransfers.StandardErc1155, Operator: operator, From: alice, To: bob, Amount: big.NewInt(200), TokenID: big.NewInt(8)},
+			},
+		},
+		{
+			name: "transfer without indexed parameters",
+			log:  &types.Log{Topics: []common.Hash{tokentransfers.TopicTransfer}, Data: concat(word(1), word(2), word(3))},
+		},
+		{
+			name: "other event",
+			log:  &types.Log{Topics: []common.Hash{common.HexToHash("0x8c5be1e5ebec7d5bd14f71427d1e84f3dd0314c0f7b2291e5b200ac8c7c3b925")}, Data: word(1)},
+		},
+	}
+
+	for _, tt := range tests {
+		tt.log.Address = token
+		transfers := tokentransfers.FromLog(tt.log)
+		if len(transfers) != len(tt.expected) {
+			t.Errorf("%s: got %d transfers, expected %d", tt.name, len(transfers), len(tt.expected))
+			continue
+		}
+		for i, transfer := range transfers {
+			e := tt.expected[i]
+			if transfer.Standard != e.Standard || transfer.Token != token || transfer.From != e.From || transfer.To != e.To || transfer.Operator != e.Operator ||
+				transfer.Amount.Cmp(e.Amount) != 0 || (e.TokenID == nil) != (transfer.TokenID == nil) || (e.TokenID != nil && e.TokenID.Cmp(transfer.TokenID) != 0) {
+				t.Errorf("%s: got %+v, expected %+v", tt.name, transfer, e)
+			}
+		}
+	}
+}
+
+func TestFromBlockEnrich(t *testing.T) {
+	chainFixtures, err := rpctest.LoadFixtures("../rpctest/testdata/chain.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainServer := rpctest.NewServer(chainFixtures)
+	defer chainServer.Close()
+	chainClient, err := ethclient.Dial(chainServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chainClient.Close()
+
+	// Block 12323931 has 3 transactions, the second one is a transfer of 12323931000 tokens
+	b, err := blockswithtx.GetBlockWithTxReceipts(chainClient, 12323931)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfers := tokentransfers.FromBlock(b)
+	if len(transfers) != 1 {
+		t.Fatalf("got %d transfers, expected 1", len(transfers))
+	}
+	transfer := transfers[0]
+	if transfer.Token != token || transfer.Amount.Int64() != 12323931000 || transfer.TxHash != b.Block.Transactions()[1].Hash() || transfer.TxIndex != 1 {
+		t.Errorf("unexpected transfer %+v", transfer)
+	}
+
+	contractFixtures, err := rpctest.LoadFixtures("../rpctest/testdata/contracts.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contractServer := rpctest.NewServer(contractFixtures)
+	defer contractServer.Close()
+	contractClient, err := ethclient.Dial(contractServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer contractClient.Close()
+
+	tokentransfers.Enrich(transfers, addresslookup.NewAddressLookupService(contractClient))
+	if transfer.TokenDetail == nil || transfer.TokenDetail.Symbol != "TST" || transfer.TokenDetail.Decimals != 18 {
+		t.Fatalf("unexpected token detail %+v", transfer.TokenDetail)
+	}
+	if amount := transfer.HumanAmount(); amount != "0.000000012323931" {
+		t.Errorf("unexpected human amount %s", amount)
+	}
+}
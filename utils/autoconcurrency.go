@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// AutoConcurrency configures the adaptive concurrency mode of the block pipelines (PipelineOptions.AutoConcurrency).
+// The pipeline starts with MinConcurrency parallel fetches and adjusts the limit every Interval, AIMD-style:
+//
+//   - if fetches failed, or the average fetch latency rose above LatencyFactor times the lowest average seen so far
+//     (the node or provider is saturated or throttling), the limit is halved
+//   - if throughput dropped compared to the previous interval, the limit is decreased by one
+//   - otherwise it is increased by one
+//
+// This way the same code runs well against a local IPC connection (high limit) and a throttled HTTP provider (low limit).
+type AutoConcurrency struct {
+	MinConcurrency int           // default: 1
+	MaxConcurrency int           // default: DefaultMaxAutoConcurrency
+	Interval       time.Duration // default: DefaultAutoConcurrencyInterval
+	LatencyFactor  float64       // default: 2
+
+	// OnChange is called with the new limit whenever it changes. Optional.
+	OnChange func(concurrency int)
+}
+
+const (
+	DefaultMaxAutoConcurrency      = 32
+	DefaultAutoConcurrencyInterval = time.Second
+)
+
+func (a AutoConcurrency) min() int {
+	if a.MinConcurrency < 1 {
+		return 1
+	}
+	return a.MinConcurrency
+}
+
+func (a AutoConcurrency) max() int {
+	if a.MaxConcurrency < 1 {
+		return DefaultMaxAutoConcurrency
+	}
+	if a.MaxConcurrency < a.min() {
+		return a.min()
+	}
+	return a.MaxConcurrency
+}
+
+func (a AutoConcurrency) interval() time.Duration {
+	if a.Interval <= 0 {
+		return DefaultAutoConcurrencyInterval
+	}
+	return a.Interval
+}
+
+func (a AutoConcurrency) latencyFactor() float64 {
+	if a.LatencyFactor <= 1 {
+		return 2
+	}
+	return a.LatencyFactor
+}
+
+// concurrencyLimiter limits the number of concurrent fetches, and adjusts the limit from the observed fetches
+type concurrencyLimiter struct {
+	config AutoConcurrency
+	now    func() time.Time
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+
+	windowStart    time.Time
+	windowFetches  int
+	windowErrors   int
+	windowLatency  time.Duration
+	prevThroughput float64
+	lowestLatency  time.Duration
+}
+
+func newConcurrencyLimiter(config AutoConcurrency) *concurrencyLimiter {
+	l := &concurrencyLimiter{config: config, now: time.Now, limit: config.min()}
+	l.cond = sync.NewCond(&l.lock)
+	l.windowStart = l.now()
+	return l
+}
+
+// acquire blocks until a fetch may start. Returns false if ctx is done.
+func (l *concurrencyLimiter) acquire(ctx context.Context) bool {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.lock.Lock()
+			l.cond.Broadcast()
+			l.lock.Unlock()
+		case <-stop:
+		}
+	}()
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for l.active >= l.limit {
+		if ctx.Err() != nil {
+			return false
+		}
+		l.cond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	l.active++
+	return true
+}
+
+// release ends a fetch, records its latency and outcome, and adjusts the limit once the interval is over
+func (l *concurrencyLimiter) release(latency time.Duration, failed bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.active--
+	l.windowFetches++
+	l.windowLatency += latency
+	if failed {
+		l.windowErrors++
+	}
+
+	elapsed := l.now().Sub(l.windowStart)
+	if elapsed >= l.config.interval() {
+		l.adjust(elapsed)
+	}
+	l.cond.Broadcast()
+}
+
+func (l *concurrencyLimiter) adjust(elapsed time.Duration) {
+	throughput := float64(l.windowFetches) / elapsed.Seconds()
+	avgLatency := l.windowLatency / time.Duration(l.windowFetches)
+	if l.lowestLatency == 0 || avgLatency < l.lowestLatency {
+		l.lowestLatency = avgLatency
+	}
+
+	limit := l.limit
+	switch {
+	case l.windowErrors > 0 || float64(avgLatency) > l.config.latencyFactor()*float64(l.lowestLatency):
+		limit /= 2
+	case throughput < l.prevThroughput*0.9:
+		limit--
+	default:
+		limit++
+	}
+	if limit < l.config.min() {
+		limit = l.config.min()
+	} else if limit > l.config.max() {
+		limit = l.config.max()
+	}
+
+	if limit != l.limit {
+		l.limit = limit
+		if l.config.OnChange != nil {
+			l.config.OnChange(limit)
+		}
+	}
+
+	l.prevThroughput = throughput
+	l.windowStart = l.now()
+	l.windowFetches = 0
+	l.windowErrors = 0
+	l.windowLatency = 0
+}
+
+// current returns the current limit
+func (l *concurrencyLimiter) current() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limit
+}
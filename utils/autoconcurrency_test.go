@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := newConcurrencyLimiter(AutoConcurrency{MinConcurrency: 2, MaxConcurrency: 8, Interval: time.Second})
+	l.now = func() time.Time { return now }
+	l.windowStart = now
+
+	// window runs n fetches with the given latency, and moves the clock to the end of the interval
+	window := func(n int, latency time.Duration, failed bool) int {
+		for i := 0; i < n; i++ {
+			if !l.acquire(context.Background()) {
+				t.Fatal("acquire failed")
+			}
+			if i == n-1 {
+				now = now.Add(time.Second)
+			}
+			l.release(latency, failed)
+		}
+		return l.current()
+	}
+
+	if limit := l.current(); limit != 2 {
+		t.Fatalf("expected to start with 2, got %d", limit)
+	}
+
+	// Additive increase while throughput grows and latency is stable
+	for expected := 3; expected <= 6; expected++ {
+		if limit := window(10*expected, 50*time.Millisecond, false); limit != expected {
+			t.Fatalf("expected %d, got %d", expected, limit)
+		}
+	}
+
+	// Multiplicative decrease on errors and high latency
+	if limit := window(60, 50*time.Millisecond, true); limit != 3 {
+		t.Fatalf("expected 3 after errors, got %d", limit)
+	}
+	if limit := window(60, 200*time.Millisecond, false); limit != 2 {
+		t.Fatalf("expected 2 (minimum) after high latency, got %d", limit)
+	}
+
+	// Throughput drop
+	window(100, 50*time.Millisecond, false) // 3
+	if limit := window(50, 50*time.Millisecond, false); limit != 2 {
+		t.Fatalf("expected 2 after throughput dropped, got %d", limit)
+	}
+
+	// Never above the maximum
+	for i := 0; i < 10; i++ {
+		window(100+10*i, 50*time.Millisecond, false)
+	}
+	if limit := l.current(); limit != 8 {
+		t.Fatalf("expected 8 (maximum), got %d", limit)
+	}
+
+	// acquire gives up on cancellation when the limit is reached
+	for i := 0; i < 8; i++ {
+		l.acquire(context.Background())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if l.acquire(ctx) {
+		t.Fatal("expected acquire to fail")
+	}
+}
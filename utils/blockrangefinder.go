@@ -9,11 +9,11 @@ import (
 	"strings"
 	"time"
 
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/go-ethutils/ethrpc"
 )
 
 // GetBlockRangeFromArguments returns start and end blocks for a given block or date range. startBlock is first block at or after the given time, endBlock is the last before the given end time.
-func FindBlockRange(client *ethclient.Client, blockHeight int, date string, hour int, min int, length string) (startBlock int64, endBlock int64, err error) {
+func FindBlockRange(client ethrpc.HeaderReader, blockHeight int, date string, hour int, min int, length string) (startBlock int64, endBlock int64, err error) {
 	if date != "" && blockHeight != 0 {
 		return startBlock, endBlock, errors.New("cannot use both block and date arguments")
 	}
@@ -0,0 +1,66 @@
+package utils_test
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/go-ethutils/rpctest"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+// The chain fixtures have blocks 12323930 to 12323970, every 13 seconds. Block 12323940 is at 2021-04-27 18:00:04 UTC.
+const chainFixtures = "../rpctest/testdata/chain.json"
+
+func newChainClient(t *testing.T) (*rpctest.Server, *ethclient.Client) {
+	fixtures, err := rpctest.LoadFixtures(chainFixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	t.Cleanup(server.Close)
+
+	client, err := ethclient.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return server, client
+}
+
+func TestFindBlockRange(t *testing.T) {
+	_, client := newChainClient(t)
+
+	tests := []struct {
+		name       string
+		block      int
+		date       string
+		hour, min  int
+		length     string
+		start, end int64
+	}{
+		{name: "block", block: 12323935, length: "", start: 12323935, end: 12323935},
+		{name: "block+num", block: 12323935, length: "5", start: 12323935, end: 12323939},
+		{name: "block+latest", block: 12323965, length: ".", start: 12323965, end: 12323970},
+		{name: "block+timespan", block: 12323940, length: "1m", start: 12323940, end: 12323944},
+		{name: "date+timespan", date: "2021-04-27", hour: 18, length: "2m", start: 12323940, end: 12323948},
+		{name: "date+num", date: "2021-04-27", hour: 18, min: 3, length: "3", start: 12323954, end: 12323956},
+	}
+
+	for _, tt := range tests {
+		start, end, err := utils.FindBlockRange(client, tt.block, tt.date, tt.hour, tt.min, tt.length)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if start != tt.start || end != tt.end {
+			t.Errorf("%s: got %d-%d, expected %d-%d", tt.name, start, end, tt.start, tt.end)
+		}
+	}
+
+	if _, _, err := utils.FindBlockRange(client, 12323935, "2021-04-27", 0, 0, "1"); err == nil {
+		t.Error("expected error when using both block and date")
+	}
+	if _, _, err := utils.FindBlockRange(client, 0, "2021-04-28", 0, 0, "1"); err != utils.ErrTargetTimestampAfterLatestBlock {
+		t.Errorf("expected ErrTargetTimestampAfterLatestBlock, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HeightRange is an inclusive range of block heights
+type HeightRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// Checkpoint is the persisted progress of a range download: all heights up to and including Contiguous are done,
+// plus the ones in Completed (which are all above Contiguous).
+type Checkpoint struct {
+	Contiguous int64         `json:"contiguous"`
+	Completed  []HeightRange `json:"completed,omitempty"`
+}
+
+// CheckpointStore persists a Checkpoint, eg. in a file or a database
+type CheckpointStore interface {
+	// Load returns the saved checkpoint. found is false if nothing was saved yet.
+	Load() (cp Checkpoint, found bool, err error)
+	Save(cp Checkpoint) error
+}
+
+// FileCheckpointStore saves the checkpoint as JSON file. Files are replaced atomically, so a crash while saving
+// leaves the previous checkpoint intact.
+type FileCheckpointStore struct {
+	Filename string
+}
+
+func NewFileCheckpointStore(filename string) *FileCheckpointStore {
+	return &FileCheckpointStore{Filename: filename}
+}
+
+func (s *FileCheckpointStore) Load() (cp Checkpoint, found bool, err error) {
+	data, err := os.ReadFile(s.Filename)
+	if os.IsNotExist(err) {
+		return cp, false, nil
+	} else if err != nil {
+		return cp, false, err
+	}
+
+	err = json.Unmarshal(data, &cp)
+	return cp, err == nil, err
+}
+
+func (s *FileCheckpointStore) Save(cp Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.Filename), filepath.Base(s.Filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) // no-op after the rename
+
+	if _, err = tmpFile.Write(data); err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), s.Filename)
+}
+
+// CheckpointerOptions configures NewCheckpointerWithOptions
+type CheckpointerOptions struct {
+	// The checkpoint is saved once SaveEvery heights have been marked done since the last save, or SaveInterval has
+	// passed, whichever comes first. If neither is set, it's saved after every height. Progress which isn't saved yet
+	// is lost on a crash (those heights are processed again after a restart), so call Close at the end.
+	SaveEvery    int
+	SaveInterval time.Duration
+}
+
+// Checkpointer tracks which heights of a range download have been processed, and saves the progress to a
+// CheckpointStore. Pass it in PipelineOptions.Checkpointer so that a restarted pipeline skips all heights which are
+// already done, call MarkDone once a block has been fully processed, and Close when done.
+type Checkpointer struct {
+	store CheckpointStore
+	opts  CheckpointerOptions
+
+	lock       sync.Mutex
+	contiguous int64
+	completed  map[int64]bool // done heights above contiguous
+	unsaved    int            // number of heights marked done since the last save
+	lastSave   time.Time
+}
+
+// NewCheckpointer loads the checkpoint from the store, and saves it after every processed height. Without a saved
+// checkpoint, the download starts at startBlock.
+func NewCheckpointer(store CheckpointStore, startBlock int64) (*Checkpointer, error) {
+	return NewCheckpointerWithOptions(store, startBlock, CheckpointerOptions{})
+}
+
+// NewCheckpointerWithOptions is like NewCheckpointer, but saves less often (see CheckpointerOptions). Useful for
+// long backfills, where saving after every block means an fsync per block.
+func NewCheckpointerWithOptions(store CheckpointStore, startBlock int64, opts CheckpointerOptions) (*Checkpointer, error) {
+	c := &Checkpointer{
+		store:      store,
+		opts:       opts,
+		contiguous: startBlock - 1,
+		completed:  make(map[int64]bool),
+		lastSave:   time.Now(),
+	}
+
+	cp, found, err := store.Load()
+	if err != nil || !found {
+		return c, err
+	}
+
+	c.contiguous = cp.Contiguous
+	for _, r := range cp.Completed {
+		for height := r.From; height <= r.To; height++ {
+			c.completed[height] = true
+		}
+	}
+	return c, nil
+}
+
+// IsDone returns true if the height was already processed
+func (c *Checkpointer) IsDone(height int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return height <= c.contiguous || c.completed[height]
+}
+
+// ResumeHeight returns the first height which is not done yet
+func (c *Checkpointer) ResumeHeight() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.contiguous + 1
+}
+
+// MarkDone records the height as processed, and saves the checkpoint if it's due (see CheckpointerOptions)
+func (c *Checkpointer) MarkDone(height int64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if height <= c.contiguous || c.completed[height] {
+		return nil
+	}
+
+	c.completed[height] = true
+	for c.completed[c.contiguous+1] {
+		delete(c.completed, c.contiguous+1)
+		c.contiguous++
+	}
+
+	c.unsaved++
+	saveEvery := c.opts.SaveEvery
+	if saveEvery < 1 && c.opts.SaveInterval <= 0 {
+		saveEvery = 1
+	}
+	if (saveEvery > 0 && c.unsaved >= saveEvery) || (c.opts.SaveInterval > 0 && time.Since(c.lastSave) >= c.opts.SaveInterval) {
+		return c.save()
+	}
+	return nil
+}
+
+// Flush saves the checkpoint if any heights were marked done since the last save
+func (c *Checkpointer) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.unsaved == 0 {
+		return nil
+	}
+	return c.save()
+}
+
+// Close saves the remaining progress (see Flush)
+func (c *Checkpointer) Close() error {
+	return c.Flush()
+}
+
+func (c *Checkpointer) save() error {
+	if err := c.store.Save(c.checkpoint()); err != nil {
+		return err // unsaved stays, the next call tries again
+	}
+	c.unsaved = 0
+	c.lastSave = time.Now()
+	return nil
+}
+
+// Checkpoint returns the current progress
+func (c *Checkpointer) Checkpoint() Checkpoint {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.checkpoint()
+}
+
+func (c *Checkpointer) checkpoint() Checkpoint {
+	cp := Checkpoint{Contiguous: c.contiguous}
+
+	heights := make([]int64, 0, len(c.completed))
+	for height := range c.completed {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	for _, height := range heights {
+		if n := len(cp.Completed); n > 0 && cp.Completed[n-1].To == height-1 {
+			cp.Completed[n-1].To = height
+		} else {
+			cp.Completed = append(cp.Completed, HeightRange{From: height, To: height})
+		}
+	}
+	return cp
+}
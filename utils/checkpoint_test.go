@@ -0,0 +1,142 @@
+package utils_test
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+// memoryCheckpointStore keeps the checkpoint in memory and counts the saves
+type memoryCheckpointStore struct {
+	cp    *utils.Checkpoint
+	saves int
+}
+
+func (s *memoryCheckpointStore) Load() (utils.Checkpoint, bool, error) {
+	if s.cp == nil {
+		return utils.Checkpoint{}, false, nil
+	}
+	return *s.cp, true, nil
+}
+
+func (s *memoryCheckpointStore) Save(cp utils.Checkpoint) error {
+	s.cp = &cp
+	s.saves++
+	return nil
+}
+
+func TestCheckpointerResume(t *testing.T) {
+	store := utils.NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	c, err := utils.NewCheckpointer(store, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ResumeHeight() != 100 {
+		t.Errorf("resume height %d without a saved checkpoint, expected the start block 100", c.ResumeHeight())
+	}
+	for _, height := range []int64{100, 101, 103, 106, 105} {
+		if err := c.MarkDone(height); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A restart loads the saved progress
+	c, err = utils.NewCheckpointer(store, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := utils.Checkpoint{Contiguous: 101, Completed: []utils.HeightRange{{From: 103, To: 103}, {From: 105, To: 106}}}
+	if cp := c.Checkpoint(); !reflect.DeepEqual(cp, expected) {
+		t.Errorf("got checkpoint %+v, expected %+v", cp, expected)
+	}
+	if c.ResumeHeight() != 102 {
+		t.Errorf("resume height %d, expected 102", c.ResumeHeight())
+	}
+	for height, done := range map[int64]bool{99: true, 101: true, 102: false, 103: true, 104: false, 106: true, 107: false} {
+		if c.IsDone(height) != done {
+			t.Errorf("IsDone(%d) = %v, expected %v", height, !done, done)
+		}
+	}
+
+	// Filling the gaps merges the completed ranges into the contiguous part
+	if err := c.MarkDone(102); err != nil {
+		t.Fatal(err)
+	}
+	expected = utils.Checkpoint{Contiguous: 103, Completed: []utils.HeightRange{{From: 105, To: 106}}}
+	if cp := c.Checkpoint(); !reflect.DeepEqual(cp, expected) {
+		t.Errorf("got checkpoint %+v, expected %+v", cp, expected)
+	}
+	if err := c.MarkDone(104); err != nil {
+		t.Fatal(err)
+	}
+	if cp := c.Checkpoint(); !reflect.DeepEqual(cp, utils.Checkpoint{Contiguous: 106}) {
+		t.Errorf("got checkpoint %+v, expected all done up to 106", cp)
+	}
+}
+
+func TestCheckpointerSaveEvery(t *testing.T) {
+	store := &memoryCheckpointStore{}
+	c, err := utils.NewCheckpointerWithOptions(store, 1, utils.CheckpointerOptions{SaveEvery: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, height := range []int64{1, 2, 2, 1, 3, 4, 5} { // repeated heights don't count
+		if err := c.MarkDone(height); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if store.saves != 1 || store.cp.Contiguous != 3 {
+		t.Errorf("%d saves with contiguous %d, expected 1 save after height 3", store.saves, store.cp.Contiguous)
+	}
+
+	// Close saves the rest, but only if there is something new
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store.saves != 2 || store.cp.Contiguous != 5 {
+		t.Errorf("%d saves with contiguous %d, expected 2 saves and contiguous 5 after Close", store.saves, store.cp.Contiguous)
+	}
+}
+
+func TestGetBlocksCheckpoint(t *testing.T) {
+	_, client := newChainClient(t)
+
+	// Everything up to 12323939 and 12323945-12323949 was processed in a previous run
+	store := &memoryCheckpointStore{cp: &utils.Checkpoint{Contiguous: 12323939, Completed: []utils.HeightRange{{From: 12323945, To: 12323949}}}}
+	checkpointer, err := utils.NewCheckpointer(store, 12323930)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockChan := make(chan *types.Block, 100)
+	opts := utils.PipelineOptions{Concurrency: 3, Checkpointer: checkpointer}
+	if err := utils.GetBlocksWithOptions(context.Background(), blockChan, client, 12323930, 12323960, opts); err != nil {
+		t.Fatal(err)
+	}
+	close(blockChan)
+
+	var fetched int
+	for block := range blockChan {
+		height := block.Number().Int64()
+		if height < 12323940 || (height >= 12323945 && height <= 12323949) {
+			t.Errorf("block %d was already done", height)
+		}
+		fetched++
+		if err := checkpointer.MarkDone(height); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fetched != 16 {
+		t.Errorf("fetched %d blocks, expected 16", fetched)
+	}
+	if cp := checkpointer.Checkpoint(); cp.Contiguous != 12323960 || len(cp.Completed) != 0 {
+		t.Errorf("got checkpoint %+v, expected all done up to 12323960", cp)
+	}
+}
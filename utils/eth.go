@@ -8,14 +8,20 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/go-ethutils/ethrpc"
 )
 
 var (
 	ErrTargetTimestampAfterLatestBlock = errors.New("target timestamp after latest block")
 )
 
+// GetTxSender returns the sender of a transaction. For the blocks of GetBlocksBatch it's the sender the node returned,
+// else it is recovered from the signature.
 func GetTxSender(tx *types.Transaction) (from common.Address, err error) {
+	if from, err = cachedSender(tx); err == nil {
+		return from, nil
+	}
+
 	from, err = types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
 	if err != nil {
 		from, err = types.Sender(types.HomesteadSigner{}, tx)
@@ -38,7 +44,7 @@ func EstimateTargetBlocknumber(utcTimestamp int64) int64 {
 
 // GetBlockHeaderAtTimestamp returns the header of the first block at or after the timestamp. If timestamp is after
 // latest block, then return latest block. This function is a bit messy, but works. Improvements to this approach are welcome.
-func GetFirstBlockHeaderAtOrAfterTime(client *ethclient.Client, targetTime time.Time) (header *types.Header, err error) {
+func GetFirstBlockHeaderAtOrAfterTime(client ethrpc.HeaderReader, targetTime time.Time) (header *types.Header, err error) {
 	// Get latest header
 	latestBlockHeader, err := client.HeaderByNumber(context.Background(), nil)
 	if err != nil {
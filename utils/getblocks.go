@@ -2,44 +2,82 @@ package utils
 
 import (
 	"context"
-	"log"
+	"errors"
 	"math/big"
-	"sync"
 
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/metachris/go-ethutils/ethrpc"
 )
 
 // GetBlocks is a fast block query pipeline. It queries blocks concurrently and pushes it into a channel for processing.
-func GetBlocks(blockChan chan<- *types.Block, client *ethclient.Client, startBlock int64, endBlock int64, concurrency int) {
-	var blockWorkerWg sync.WaitGroup         // for waiting for all workers to finish
-	blockHeightChan := make(chan int64, 100) // channel for workers to know which heights to download
-
-	// Start eth client thread pool
-	for w := 1; w <= concurrency; w++ {
-		blockWorkerWg.Add(1)
-
-		// Worker gets a block height from blockHeightChan, downloads it, and puts it in the blockChan
-		go func() {
-			defer blockWorkerWg.Done()
-			for blockHeight := range blockHeightChan {
-				// fmt.Println(blockHeight)
-				block, err := client.BlockByNumber(context.Background(), big.NewInt(blockHeight))
-				if err != nil {
-					log.Println("Error getting block:", blockHeight, err)
-					continue
-				}
-				blockChan <- block
-			}
-		}()
+// Blocks that could not be downloaded are skipped, and reported in the returned *BlockRangeError.
+func GetBlocks(blockChan chan<- *types.Block, client ethrpc.BlockReader, startBlock int64, endBlock int64, concurrency int) error {
+	return GetBlocksContext(context.Background(), blockChan, client, startBlock, endBlock, concurrency)
+}
+
+// GetBlocksContext is like GetBlocks, but can be cancelled through ctx (in which case ctx.Err() is returned)
+func GetBlocksContext(ctx context.Context, blockChan chan<- *types.Block, client ethrpc.BlockReader, startBlock int64, endBlock int64, concurrency int) error {
+	return GetBlocksWithOptions(ctx, blockChan, client, startBlock, endBlock, PipelineOptions{Concurrency: concurrency})
+}
+
+// GetBlocksWithOptions is like GetBlocksContext, with additional options (eg. a retry policy). If opts.BatchSize > 1,
+// each worker requests that many blocks in a single JSON-RPC batch request (see PipelineOptions.GetRPCCaller).
+// The number of attempts is only reported for heights that failed (BlockError.Attempts), a *types.Block has no room
+// for it. blockswithtx reports it for every block (BlockWithTxReceipts.Attempts).
+func GetBlocksWithOptions(ctx context.Context, blockChan chan<- *types.Block, client ethrpc.BlockReader, startBlock int64, endBlock int64, opts PipelineOptions) error {
+	pipeline, err := newGetBlocksPipeline(blockChan, client, opts)
+	if err != nil {
+		return err
 	}
+	return pipeline.Run(ctx, startBlock, endBlock)
+}
 
-	// Push blockheights into channel, for workers to pick up
-	for currentBlockNumber := startBlock; currentBlockNumber <= endBlock; currentBlockNumber++ {
-		blockHeightChan <- currentBlockNumber
+// FollowBlocks sends all blocks from startBlock up to the chain head to blockChan, and then keeps streaming new blocks
+// as they arrive (lagging opts.Confirmations blocks behind the head). It uses a newHeads subscription on WebSocket/IPC
+// connections and polls the head on HTTP. Runs until ctx is done.
+func FollowBlocks(ctx context.Context, blockChan chan<- *types.Block, client ethrpc.ChainReader, startBlock int64, opts PipelineOptions) error {
+	pipeline, err := newGetBlocksPipeline(blockChan, client, opts)
+	if err != nil {
+		return err
+	}
+	return pipeline.Follow(ctx, client, startBlock)
+}
+
+func newGetBlocksPipeline(blockChan chan<- *types.Block, client ethrpc.BlockReader, opts PipelineOptions) (*BlockPipeline, error) {
+	rpcCaller := opts.GetRPCCaller(client)
+	if opts.BatchSize > 1 && rpcCaller == nil {
+		return nil, errors.New("batch requests need a raw RPC connection (PipelineOptions.RPC)")
 	}
 
-	// Close worker channel and wait for workers to finish
-	close(blockHeightChan)
-	blockWorkerWg.Wait()
+	return &BlockPipeline{
+		Options: opts,
+		Fetch: func(ctx context.Context, height int64) (interface{}, error) {
+			// The attempts are only reported on failure, in the *RetryError
+			var block *types.Block
+			_, err := opts.Retry.Do(ctx, func(ctx context.Context) (err error) {
+				block, err = client.BlockByNumber(ctx, big.NewInt(height))
+				return err
+			})
+			return block, err
+		},
+		FetchBatch: func(ctx context.Context, heights []int64) ([]interface{}, []error) {
+			blocks, errs := GetBlocksBatch(ctx, rpcCaller, heights, opts.Retry)
+			results := make([]interface{}, len(blocks))
+			for i, block := range blocks {
+				results[i] = block
+			}
+			return results, errs
+		},
+		Emit: func(ctx context.Context, result interface{}) error {
+			select {
+			case blockChan <- result.(*types.Block):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		Items: func(result interface{}) int {
+			return len(result.(*types.Block).Transactions())
+		},
+	}, nil
 }
@@ -0,0 +1,109 @@
+package utils_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+func TestGetBlocks(t *testing.T) {
+	_, client := newChainClient(t)
+
+	blockChan := make(chan *types.Block, 100)
+	if err := utils.GetBlocks(blockChan, client, 12323930, 12323970, 5); err != nil {
+		t.Fatal(err)
+	}
+	close(blockChan)
+
+	seen := make(map[int64]bool)
+	for block := range blockChan {
+		seen[block.Number().Int64()] = true
+	}
+	if len(seen) != 41 {
+		t.Errorf("got %d blocks, expected 41", len(seen))
+	}
+}
+
+func TestGetBlocksOrderedBatch(t *testing.T) {
+	server, client := newChainClient(t)
+	rpcClient, err := rpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rpcClient.Close()
+
+	blockChan := make(chan *types.Block, 100)
+	opts := utils.PipelineOptions{Concurrency: 3, RPC: rpcClient, BatchSize: 4, Ordered: true}
+	err = utils.GetBlocksWithOptions(context.Background(), blockChan, client, 12323930, 12323969, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(blockChan)
+
+	var prev *types.Block
+	count := 0
+	for block := range blockChan {
+		if prev != nil && block.ParentHash() != prev.Hash() {
+			t.Errorf("block %d doesn't follow block %d", block.Number(), prev.Number())
+		}
+		prev = block
+		count++
+	}
+	if count != 40 {
+		t.Errorf("got %d blocks, expected 40", count)
+	}
+	if n := server.Calls("eth_getBlockByNumber"); n != 40 {
+		t.Errorf("eth_getBlockByNumber called %d times, expected 40 (in 10 batches)", n)
+	}
+
+	// Missing blocks are reported
+	blockChan = make(chan *types.Block, 100)
+	opts.Retry.MaxAttempts = 1
+	err = utils.GetBlocksWithOptions(context.Background(), blockChan, client, 12323968, 12323972, opts)
+	rangeErr, ok := err.(*utils.BlockRangeError)
+	if !ok {
+		t.Fatalf("expected BlockRangeError, got %v", err)
+	}
+	if heights := rangeErr.Heights(); len(heights) != 2 || heights[0] != 12323971 || heights[1] != 12323972 {
+		t.Errorf("unexpected failed heights %v", heights)
+	}
+	if len(blockChan) != 3 {
+		t.Errorf("got %d blocks, expected 3", len(blockChan))
+	}
+}
+
+func TestGetBlocksAutoConcurrency(t *testing.T) {
+	_, client := newChainClient(t)
+
+	var changes []int
+	auto := &utils.AutoConcurrency{MaxConcurrency: 4, Interval: time.Millisecond, OnChange: func(concurrency int) {
+		changes = append(changes, concurrency) // called with the limiter's lock held
+	}}
+
+	blockChan := make(chan *types.Block, 100)
+	opts := utils.PipelineOptions{AutoConcurrency: auto, Ordered: true}
+	if err := utils.GetBlocksWithOptions(context.Background(), blockChan, client, 12323930, 12323970, opts); err != nil {
+		t.Fatal(err)
+	}
+	close(blockChan)
+
+	height := int64(12323930)
+	for block := range blockChan {
+		if block.Number().Int64() != height {
+			t.Fatalf("got block %d, expected %d", block.Number(), height)
+		}
+		height++
+	}
+	if height != 12323971 {
+		t.Errorf("got blocks up to %d, expected 12323970", height-1)
+	}
+	for _, concurrency := range changes {
+		if concurrency < 1 || concurrency > 4 {
+			t.Errorf("concurrency %d out of bounds", concurrency)
+		}
+	}
+}
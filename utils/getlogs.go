@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// DefaultLogChunkSize is the number of blocks GetLogs queries per eth_getLogs call, until the node rejects a range
+const DefaultLogChunkSize = 2000
+
+// LogOptions configures GetLogsWithOptions
+type LogOptions struct {
+	Concurrency int         // number of parallel eth_getLogs calls
+	Retry       RetryPolicy // retry policy for the individual calls (ranges rejected as too large are split, not retried)
+
+	// ChunkSize is the initial number of blocks per eth_getLogs call (defaults to DefaultLogChunkSize). Whenever the
+	// node rejects a range as too large (see ethrpc.IsLogRangeTooLarge), the range is split in half, and the rest of
+	// the scan uses the smaller size.
+	ChunkSize int64
+}
+
+// LogRangeError describes a block range whose logs could not be fetched
+type LogRangeError struct {
+	FromBlock int64
+	ToBlock   int64
+	Attempts  int // number of attempts made for the failing call
+	Err       error
+}
+
+func (e *LogRangeError) Error() string {
+	return fmt.Sprintf("logs of blocks %d-%d: %v", e.FromBlock, e.ToBlock, e.Err)
+}
+
+func (e *LogRangeError) Unwrap() error {
+	return e.Err
+}
+
+// LogScanError is returned by GetLogs if the logs of some block ranges could not be fetched. The logs of all other
+// ranges were still sent to the log channel.
+type LogScanError struct {
+	Errors []*LogRangeError // sorted by block
+}
+
+func (e *LogScanError) Error() string {
+	ranges := make([]string, len(e.Errors))
+	for i, rangeErr := range e.Errors {
+		ranges[i] = fmt.Sprintf("%d-%d", rangeErr.FromBlock, rangeErr.ToBlock)
+	}
+	return fmt.Sprintf("failed to fetch logs of %d block ranges: %s (first error: %v)", len(e.Errors), strings.Join(ranges, ", "), e.Errors[0].Err)
+}
+
+// GetLogs sends all logs matching the query (addresses and topics) from startBlock to endBlock (inclusive) to logChan,
+// in block order. The range is queried in chunks by concurrent workers, chunks which the node rejects as too large
+// are split. Ranges that could not be fetched are skipped, and reported in the returned *LogScanError.
+func GetLogs(logChan chan<- types.Log, client ethrpc.LogFilterer, query ethereum.FilterQuery, startBlock int64, endBlock int64, concurrency int) error {
+	return GetLogsWithOptions(context.Background(), logChan, client, query, startBlock, endBlock, LogOptions{Concurrency: concurrency})
+}
+
+// GetLogsWithOptions is like GetLogs, with additional options (eg. a retry policy). It can be cancelled through ctx
+// (in which case ctx.Err() is returned).
+func GetLogsWithOptions(ctx context.Context, logChan chan<- types.Log, client ethrpc.LogFilterer, query ethereum.FilterQuery, startBlock int64, endBlock int64, opts LogOptions) error {
+	if query.BlockHash != nil {
+		return errors.New("GetLogs queries a block range, the query must not have a BlockHash")
+	}
+
+	s := &logScanner{client: client, query: query, retry: opts.Retry, chunkSize: opts.ChunkSize}
+	if s.chunkSize < 1 {
+		s.chunkSize = DefaultLogChunkSize
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	type chunk struct {
+		seq      int
+		from, to int64
+	}
+	type chunkResult struct {
+		seq  int
+		logs []types.Log
+		errs []*LogRangeError
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The window bounds the number of chunks that are in flight or waiting to be emitted
+	window := make(chan struct{}, 2*concurrency)
+	chunks := make(chan chunk)
+	go func() {
+		defer close(chunks)
+		seq := 0
+		for from := startBlock; from <= endBlock; seq++ {
+			select {
+			case window <- struct{}{}:
+			case <-runCtx.Done():
+				return
+			}
+
+			// Chunks are created lazily, so that they use the chunk size learned from previous splits
+			to := from + s.currentChunkSize() - 1
+			if to > endBlock {
+				to = endBlock
+			}
+			select {
+			case chunks <- chunk{seq: seq, from: from, to: to}:
+			case <-runCtx.Done():
+				return
+			}
+			from = to + 1
+		}
+	}()
+
+	results := make(chan chunkResult)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				logs, errs := s.fetch(runCtx, c.from, c.to)
+				select {
+				case results <- chunkResult{seq: c.seq, logs: logs, errs: errs}:
+				case <-runCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Emit the chunks in order
+	var rangeErrs []*LogRangeError
+	pending := make(map[int]chunkResult)
+	next := 0
+	for res := range results {
+		pending[res.seq] = res
+		for r, found := pending[next]; found && runCtx.Err() == nil; r, found = pending[next] {
+			delete(pending, next)
+			next++
+			rangeErrs = append(rangeErrs, r.errs...)
+			for _, log := range r.logs {
+				select {
+				case logChan <- log:
+				case <-runCtx.Done():
+				}
+			}
+			<-window
+		}
+		if runCtx.Err() != nil {
+			cancel() // stop the workers, and drain the results until they returned
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(rangeErrs) > 0 {
+		sort.Slice(rangeErrs, func(i, j int) bool { return rangeErrs[i].FromBlock < rangeErrs[j].FromBlock })
+		return &LogScanError{Errors: rangeErrs}
+	}
+	return nil
+}
+
+type logScanner struct {
+	client ethrpc.LogFilterer
+	query  ethereum.FilterQuery
+	retry  RetryPolicy
+
+	lock      sync.Mutex
+	chunkSize int64
+}
+
+func (s *logScanner) currentChunkSize() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.chunkSize
+}
+
+func (s *logScanner) shrinkChunkSize(size int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if size < s.chunkSize {
+		s.chunkSize = size
+	}
+}
+
+// fetch gets the logs of a block range, and splits it in half (recursively) if the node rejects it as too large
+func (s *logScanner) fetch(ctx context.Context, from int64, to int64) (logs []types.Log, errs []*LogRangeError) {
+	query := s.query
+	query.FromBlock = big.NewInt(from)
+	query.ToBlock = big.NewInt(to)
+
+	attempts, err := s.retry.Do(ctx, func(ctx context.Context) (err error) {
+		logs, err = s.client.FilterLogs(ctx, query)
+		return err
+	})
+	if err == nil {
+		return logs, nil
+	}
+
+	if ethrpc.IsLogRangeTooLarge(err) && from < to && ctx.Err() == nil {
+		mid := from + (to-from)/2
+		s.shrinkChunkSize(mid - from + 1)
+		logs, errs = s.fetch(ctx, from, mid)
+		upperLogs, upperErrs := s.fetch(ctx, mid+1, to)
+		return append(logs, upperLogs...), append(errs, upperErrs...)
+	}
+	return nil, []*LogRangeError{{FromBlock: from, ToBlock: to, Attempts: attempts, Err: err}}
+}
@@ -0,0 +1,78 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+// logFilterer has one log per block, and rejects queries with more than maxResults logs like Infura does
+type logFilterer struct {
+	maxResults  int64
+	failingFrom int64 // queries including this block fail (0 = none)
+	calls       int64
+}
+
+func (f *logFilterer) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	atomic.AddInt64(&f.calls, 1)
+	from, to := q.FromBlock.Int64(), q.ToBlock.Int64()
+	if f.failingFrom > 0 && from <= f.failingFrom && f.failingFrom <= to {
+		return nil, errors.New("internal error")
+	}
+	if to-from+1 > f.maxResults {
+		return nil, fmt.Errorf("query returned more than %d results", f.maxResults)
+	}
+
+	logs := make([]types.Log, 0, to-from+1)
+	for block := from; block <= to; block++ {
+		logs = append(logs, types.Log{BlockNumber: uint64(block)})
+	}
+	return logs, nil
+}
+
+func TestGetLogs(t *testing.T) {
+	client := &logFilterer{maxResults: 7}
+	logChan := make(chan types.Log, 1000)
+	opts := utils.LogOptions{Concurrency: 4, ChunkSize: 50}
+	if err := utils.GetLogsWithOptions(context.Background(), logChan, client, ethereum.FilterQuery{}, 1, 500, opts); err != nil {
+		t.Fatal(err)
+	}
+	close(logChan)
+
+	next := uint64(1)
+	for log := range logChan {
+		if log.BlockNumber != next {
+			t.Fatalf("got log of block %d, expected %d", log.BlockNumber, next)
+		}
+		next++
+	}
+	if next != 501 {
+		t.Errorf("got logs up to block %d, expected 500", next-1)
+	}
+
+	// After the first splits, the chunk size shrinks to one that works (50 -> 25 -> 13 -> 7)
+	if client.calls > 150 {
+		t.Errorf("%d eth_getLogs calls, expected the chunk size to adapt", client.calls)
+	}
+}
+
+func TestGetLogsFailedRange(t *testing.T) {
+	client := &logFilterer{maxResults: 10, failingFrom: 25}
+	logChan := make(chan types.Log, 1000)
+	err := utils.GetLogsWithOptions(context.Background(), logChan, client, ethereum.FilterQuery{}, 1, 100, utils.LogOptions{Concurrency: 2, ChunkSize: 10})
+	close(logChan)
+
+	var scanErr *utils.LogScanError
+	if !errors.As(err, &scanErr) || len(scanErr.Errors) != 1 || scanErr.Errors[0].FromBlock != 21 || scanErr.Errors[0].ToBlock != 30 {
+		t.Fatalf("expected LogScanError for blocks 21-30, got %v", err)
+	}
+	if len(logChan) != 90 {
+		t.Errorf("got %d logs, expected 90", len(logChan))
+	}
+}
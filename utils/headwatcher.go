@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// DefaultPollInterval is how often the chain head is polled in follow mode, if the node can't push new heads
+const DefaultPollInterval = 5 * time.Second
+
+// headWatcher waits for the chain to reach a certain height. It uses a newHeads subscription where possible
+// (WebSocket and IPC connections), and falls back to polling (HTTP).
+type headWatcher struct {
+	client        ethrpc.ChainReader
+	confirmations int64
+	pollInterval  time.Duration
+	retry         RetryPolicy
+
+	sub             ethereum.Subscription
+	heads           chan *types.Header
+	cannotSubscribe bool
+}
+
+func newHeadWatcher(client ethrpc.ChainReader, opts PipelineOptions) *headWatcher {
+	w := &headWatcher{
+		client:        client,
+		confirmations: opts.Confirmations,
+		pollInterval:  opts.PollInterval,
+		retry:         opts.Retry,
+		heads:         make(chan *types.Header, 16),
+	}
+	if w.pollInterval <= 0 {
+		w.pollInterval = DefaultPollInterval
+	}
+	return w
+}
+
+// waitForHeight blocks until the chain head is at least height+confirmations, and returns the highest height with
+// enough confirmations.
+func (w *headWatcher) waitForHeight(ctx context.Context, height int64) (safeHeight int64, err error) {
+	for {
+		var header *types.Header
+		_, err = w.retry.Do(ctx, func(ctx context.Context) (err error) {
+			header, err = w.client.HeaderByNumber(ctx, nil)
+			return err
+		})
+		if err != nil {
+			return safeHeight, err
+		}
+
+		safeHeight = header.Number.Int64() - w.confirmations
+		if safeHeight >= height {
+			return safeHeight, nil
+		}
+
+		if err := w.waitForNewHead(ctx); err != nil {
+			return safeHeight, err
+		}
+	}
+}
+
+// waitForNewHead returns when a new head was announced, or after the poll interval
+func (w *headWatcher) waitForNewHead(ctx context.Context) error {
+	if w.sub == nil && !w.cannotSubscribe {
+		sub, err := w.client.SubscribeNewHead(ctx, w.heads)
+		if err != nil {
+			w.cannotSubscribe = true // eg. rpc.ErrNotificationsUnsupported on HTTP connections
+		} else {
+			w.sub = sub
+		}
+	}
+
+	var subErr <-chan error
+	if w.sub != nil {
+		subErr = w.sub.Err()
+	}
+
+	timer := time.NewTimer(w.pollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-w.heads:
+		w.drainHeads() // only the latest head matters
+	case <-subErr:
+		w.sub = nil // subscription dropped, resubscribe next time
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (w *headWatcher) drainHeads() {
+	for {
+		select {
+		case <-w.heads:
+		default:
+			return
+		}
+	}
+}
+
+func (w *headWatcher) close() {
+	if w.sub != nil {
+		w.sub.Unsubscribe()
+	}
+}
@@ -0,0 +1,134 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/metachris/go-ethutils/rpctest"
+	"github.com/metachris/go-ethutils/utils"
+)
+
+// subscribingClient is an HTTP client with a fake newHeads subscription, which the test delivers heads to
+type subscribingClient struct {
+	*ethclient.Client
+	subscribed chan *fakeSubscription
+}
+
+type fakeSubscription struct {
+	heads chan<- *types.Header
+	err   chan error
+
+	lock         sync.Mutex
+	unsubscribed bool
+}
+
+func (s *fakeSubscription) Err() <-chan error {
+	return s.err
+}
+
+func (s *fakeSubscription) Unsubscribe() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.unsubscribed = true
+}
+
+func (c *subscribingClient) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	sub := &fakeSubscription{heads: ch, err: make(chan error, 1)}
+	c.subscribed <- sub
+	return sub, nil
+}
+
+// addNextBlock adds an empty block on top of the head of the fixtures, and returns its header
+func addNextBlock(t *testing.T, server *rpctest.Server, client *ethclient.Client) *types.Header {
+	t.Helper()
+	parent, err := client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Root:       parent.Root,
+		Difficulty: parent.Difficulty,
+		Number:     new(big.Int).Add(parent.Number, big.NewInt(1)),
+		GasLimit:   parent.GasLimit,
+		Time:       parent.Time + 13,
+		BaseFee:    parent.BaseFee,
+	}
+	block := types.NewBlock(header, nil, nil, nil, trie.NewStackTrie(nil))
+	if err := server.Fixtures.AddBlock(block, nil, true); err != nil {
+		t.Fatal(err)
+	}
+	return block.Header()
+}
+
+func TestFollowBlocksSubscription(t *testing.T) {
+	server, ethClient := newChainClient(t)
+	client := &subscribingClient{Client: ethClient, subscribed: make(chan *fakeSubscription, 1)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Without a new head, the pipeline would wait for an hour
+	blockChan := make(chan *types.Block)
+	opts := utils.PipelineOptions{Concurrency: 2, Ordered: true, PollInterval: time.Hour}
+	followErr := make(chan error, 1)
+	go func() {
+		followErr <- utils.FollowBlocks(ctx, blockChan, client, 12323965, opts)
+	}()
+
+	nextBlock := func(height int64) {
+		t.Helper()
+		select {
+		case block := <-blockChan:
+			if block.Number().Int64() != height {
+				t.Fatalf("got block %d, expected %d", block.Number(), height)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("block %d not received", height)
+		}
+	}
+	subscription := func() *fakeSubscription {
+		t.Helper()
+		select {
+		case sub := <-client.subscribed:
+			return sub
+		case <-time.After(5 * time.Second):
+			t.Fatal("no subscription")
+			return nil
+		}
+	}
+
+	for height := int64(12323965); height <= 12323970; height++ {
+		nextBlock(height)
+	}
+
+	// At the head it subscribes, and fetches the next block once it's announced
+	sub := subscription()
+	sub.heads <- addNextBlock(t, server, ethClient)
+	nextBlock(12323971)
+
+	// After the subscription failed it resubscribes
+	sub.err <- errors.New("websocket: close 1006 (abnormal closure)")
+	sub = subscription()
+	sub.heads <- addNextBlock(t, server, ethClient)
+	nextBlock(12323972)
+
+	cancel()
+	if err := <-followErr; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+	if !sub.unsubscribed {
+		t.Error("subscription not closed")
+	}
+}
@@ -0,0 +1,486 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// BlockError describes a block height that could not be fetched by a block pipeline
+type BlockError struct {
+	Height   int64
+	Attempts int // number of attempts made for the failing call
+	Err      error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("block %d: %v", e.Height, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// BlockRangeError is returned by the block pipelines if some heights of the range could not be fetched. The pipeline
+// still processes all other heights, so everything not listed here was sent to the block channel.
+type BlockRangeError struct {
+	Errors []*BlockError // sorted by height
+}
+
+// Heights returns all block heights that could not be fetched, in ascending order
+func (e *BlockRangeError) Heights() []int64 {
+	heights := make([]int64, len(e.Errors))
+	for i, blockErr := range e.Errors {
+		heights[i] = blockErr.Height
+	}
+	return heights
+}
+
+func (e *BlockRangeError) Error() string {
+	heights := make([]string, len(e.Errors))
+	for i, blockErr := range e.Errors {
+		heights[i] = fmt.Sprint(blockErr.Height)
+	}
+	return fmt.Sprintf("failed to fetch %d blocks: %s (first error: %v)", len(e.Errors), strings.Join(heights, ", "), e.Errors[0].Err)
+}
+
+// PipelineOptions configures the concurrent block pipelines (GetBlocks and blockswithtx.GetBlocksWithTxReceipts)
+type PipelineOptions struct {
+	Concurrency int         // number of parallel workers (ignored with AutoConcurrency)
+	Retry       RetryPolicy // retry policy for the individual RPC calls (the zero value doesn't retry)
+
+	// RPC is an optional raw JSON-RPC connection to the same node (eg. the *rpc.Client the ethclient.Client was created
+	// with). It allows fetching all receipts of a block with eth_getBlockReceipts or batch requests. Not needed if
+	// the client has its own (like *ethrpc.Client and *ethrpc.Pool).
+	RPC ethrpc.RPCCaller
+
+	// BatchSize groups this many heights into a single JSON-RPC batch request per worker (requires RPC). Only used by
+	// GetBlocks, and most useful for HTTP endpoints where every call is a separate round-trip.
+	BatchSize int
+
+	// Ordered emits the blocks strictly in ascending height order (failed heights are skipped). Workers still fetch
+	// concurrently, but never more than ReorderWindow heights ahead of the next block to emit, which bounds the
+	// number of buffered blocks. ReorderWindow defaults to 4x Concurrency (the maximum with AutoConcurrency) times
+	// BatchSize.
+	Ordered       bool
+	ReorderWindow int
+
+	// Confirmations and PollInterval are used in follow mode (FollowBlocks, blockswithtx.FollowBlocksWithTxReceipts):
+	// blocks are only fetched once they have this many blocks on top of them, and if the node doesn't support newHeads
+	// subscriptions (HTTP), the head is polled every PollInterval (defaults to DefaultPollInterval).
+	Confirmations int64
+	PollInterval  time.Duration
+
+	// Checkpointer makes the pipeline skip all heights which were already processed in a previous run. The consumer
+	// is responsible for calling Checkpointer.MarkDone for every block once it has been processed.
+	Checkpointer *Checkpointer
+
+	// OnError is called for every height that could not be fetched, as soon as it failed. Optional, but useful in
+	// follow mode, which only returns once ctx is done.
+	OnError func(blockErr *BlockError)
+
+	// AutoConcurrency adjusts the number of parallel fetches to the observed throughput, latency and errors, instead
+	// of using a fixed Concurrency (see AutoConcurrency). Optional.
+	AutoConcurrency *AutoConcurrency
+
+	// Observer receives metrics events (progress, fetch durations, worker utilization and queue depth), eg. a
+	// *metrics.Collector. Optional.
+	Observer PipelineObserver
+}
+
+// PipelineObserver receives metrics events from a block pipeline. The methods are called concurrently from the
+// workers, and should return quickly.
+type PipelineObserver interface {
+	// RangeUpdated is called when the pipeline starts, and whenever the end of the range moves up in follow mode
+	RangeUpdated(startBlock int64, endBlock int64)
+
+	// BlockFetched is called after fetching a height (with the duration of the whole batch if batching), err is set if
+	// it failed after all retries
+	BlockFetched(height int64, duration time.Duration, err error)
+
+	// BlockSkipped is called for heights which were already done according to the checkpoint
+	BlockSkipped(height int64)
+
+	// BlockEmitted is called after a block was handed to the consumer. items is the number of items in it (eg.
+	// transactions), or 0 if unknown.
+	BlockEmitted(height int64, items int)
+
+	// WorkersBusy is called whenever a worker starts or finishes fetching
+	WorkersBusy(busy int, total int)
+
+	// QueueDepth is called whenever the number of fetched blocks waiting to be handed to the consumer changes
+	QueueDepth(depth int)
+}
+
+// GetRPCCaller returns the raw JSON-RPC connection to use with this client: o.RPC if set, else the client's own
+// (see ethrpc.GetRPCCaller). Returns nil if there is none.
+func (o PipelineOptions) GetRPCCaller(client interface{}) ethrpc.RPCCaller {
+	if o.RPC != nil {
+		return o.RPC
+	}
+	return ethrpc.GetRPCCaller(client)
+}
+
+// concurrency returns the number of workers (the maximum with AutoConcurrency)
+func (o PipelineOptions) concurrency() int {
+	if o.AutoConcurrency != nil {
+		return o.AutoConcurrency.max()
+	}
+	if o.Concurrency < 1 {
+		return 1
+	}
+	return o.Concurrency
+}
+
+func (o PipelineOptions) batchSize() int {
+	if o.BatchSize < 1 {
+		return 1
+	}
+	return o.BatchSize
+}
+
+func (o PipelineOptions) reorderWindow() int {
+	if o.ReorderWindow < 1 {
+		return 4 * o.concurrency() * o.batchSize()
+	}
+	if o.ReorderWindow < o.batchSize() {
+		return o.batchSize() // a whole batch must fit into the window
+	}
+	return o.ReorderWindow
+}
+
+// BlockPipeline fetches a range of block heights concurrently and hands each result to Emit. It is the engine behind
+// GetBlocks and blockswithtx.GetBlocksWithTxReceipts.
+type BlockPipeline struct {
+	Options PipelineOptions
+
+	// Fetch downloads the data for a single block height
+	Fetch func(ctx context.Context, height int64) (interface{}, error)
+
+	// FetchBatch downloads the data for several heights at once, returning a result and an error for each height. It
+	// is used instead of Fetch if Options.BatchSize > 1. Optional.
+	FetchBatch func(ctx context.Context, heights []int64) ([]interface{}, []error)
+
+	// Emit receives each successfully fetched result (usually sends it to a channel). It should give up and return
+	// ctx.Err() once ctx is done.
+	Emit func(ctx context.Context, result interface{}) error
+
+	// Items returns the number of items in a result (eg. transactions), which is reported to Options.Observer. Optional.
+	Items func(result interface{}) int
+}
+
+// pipelineMetrics reports to the observer of a pipeline run. All methods are no-ops without observer.
+type pipelineMetrics struct {
+	observer PipelineObserver
+	workers  func() int
+	busy     int64
+	queued   int64
+}
+
+func (m *pipelineMetrics) rangeUpdated(startBlock int64, endBlock int64) {
+	if m.observer != nil {
+		m.observer.RangeUpdated(startBlock, endBlock)
+	}
+}
+
+// fetch runs fn as a busy worker, and reports the result for each height
+func (m *pipelineMetrics) fetch(heights []int64, fn func() []error) {
+	if m.observer == nil {
+		fn()
+		return
+	}
+
+	m.observer.WorkersBusy(int(atomic.AddInt64(&m.busy, 1)), m.workers())
+	start := time.Now()
+	errs := fn()
+	duration := time.Since(start)
+	m.observer.WorkersBusy(int(atomic.AddInt64(&m.busy, -1)), m.workers())
+
+	for i, height := range heights {
+		m.observer.BlockFetched(height, duration, errs[i])
+	}
+}
+
+func (m *pipelineMetrics) skipped(height int64) {
+	if m.observer != nil {
+		m.observer.BlockSkipped(height)
+	}
+}
+
+// emit reports a result as queued while emit runs, and as emitted if that succeeded
+func (m *pipelineMetrics) emit(ctx context.Context, p *BlockPipeline, height int64, result interface{}) error {
+	if m.observer == nil {
+		return p.Emit(ctx, result)
+	}
+
+	m.observer.QueueDepth(int(atomic.AddInt64(&m.queued, 1)))
+	err := p.Emit(ctx, result)
+	m.observer.QueueDepth(int(atomic.AddInt64(&m.queued, -1)))
+
+	if err == nil {
+		items := 0
+		if p.Items != nil {
+			items = p.Items(result)
+		}
+		m.observer.BlockEmitted(height, items)
+	}
+	return err
+}
+
+// buffered reports a change of results buffered by the ordered emitter
+func (m *pipelineMetrics) buffered(delta int64) {
+	if m.observer != nil {
+		m.observer.QueueDepth(int(atomic.AddInt64(&m.queued, delta)))
+	}
+}
+
+// fetchResult is what a worker hands to the ordered emitter
+type fetchResult struct {
+	height  int64
+	result  interface{}
+	err     error
+	skipped bool // already done according to the checkpoint
+}
+
+// Run fetches all blocks from startBlock to endBlock (inclusive). If ctx is cancelled it stops handing out heights,
+// waits for the workers to return and returns ctx.Err(). Otherwise a *BlockRangeError is returned if any height failed.
+func (p *BlockPipeline) Run(ctx context.Context, startBlock int64, endBlock int64) error {
+	return p.run(ctx, startBlock, endBlock, nil)
+}
+
+// Follow fetches all blocks from startBlock up to the chain head (minus Options.Confirmations), and then keeps
+// fetching new blocks as they arrive. It only returns once ctx is done, or if the chain head can't be queried.
+func (p *BlockPipeline) Follow(ctx context.Context, client ethrpc.ChainReader, startBlock int64) error {
+	heads := newHeadWatcher(client, p.Options)
+	defer heads.close()
+	return p.run(ctx, startBlock, startBlock-1, heads)
+}
+
+// run fetches the range from startBlock to endBlock. If heads is set, endBlock is moved up as the chain grows.
+func (p *BlockPipeline) run(ctx context.Context, startBlock int64, endBlock int64, heads *headWatcher) error {
+	var blockWorkerWg sync.WaitGroup           // for waiting for all workers to finish
+	blockHeightChan := make(chan []int64, 100) // channel for workers to know which heights to download
+
+	var errorsLock sync.Mutex
+	var blockErrors []*BlockError
+
+	checkpointer := p.Options.Checkpointer
+	if checkpointer != nil && checkpointer.ResumeHeight() > startBlock {
+		startBlock = checkpointer.ResumeHeight()
+	}
+
+	batchSize := 1
+	if p.FetchBatch != nil {
+		batchSize = p.Options.batchSize()
+	}
+
+	// With AutoConcurrency, all workers are started, but the limiter only lets some of them fetch at the same time
+	var limiter *concurrencyLimiter
+	metrics := &pipelineMetrics{observer: p.Options.Observer, workers: p.Options.concurrency}
+	if p.Options.AutoConcurrency != nil {
+		limiter = newConcurrencyLimiter(*p.Options.AutoConcurrency)
+		metrics.workers = limiter.current
+	}
+	metrics.rangeUpdated(startBlock, endBlock)
+
+	// In ordered mode, workers send their results to the emitter goroutine, which buffers and emits them in order.
+	// The producer needs a free window slot for every height it hands out, which the emitter frees once it's passed.
+	var window chan struct{}
+	var resultChan chan fetchResult
+	emitterDone := make(chan struct{})
+	if p.Options.Ordered {
+		window = make(chan struct{}, p.Options.reorderWindow())
+		resultChan = make(chan fetchResult, p.Options.concurrency()*batchSize)
+		go p.emitOrdered(ctx, startBlock, resultChan, window, metrics, emitterDone)
+	} else {
+		close(emitterDone)
+	}
+
+	// deliver records a failed height, and passes the result on to the emitter or Emit. Returns false if the worker should stop.
+	deliver := func(blockHeight int64, res interface{}, err error) bool {
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+
+			blockErr := &BlockError{Height: blockHeight, Attempts: 1, Err: err}
+			var retryErr *RetryError
+			if errors.As(err, &retryErr) {
+				blockErr.Attempts = retryErr.Attempts
+			}
+
+			errorsLock.Lock()
+			blockErrors = append(blockErrors, blockErr)
+			errorsLock.Unlock()
+			if p.Options.OnError != nil {
+				p.Options.OnError(blockErr)
+			}
+		}
+
+		if p.Options.Ordered {
+			select {
+			case resultChan <- fetchResult{height: blockHeight, result: res, err: err}:
+			case <-ctx.Done():
+				return false
+			}
+		} else if err == nil {
+			if err := metrics.emit(ctx, p, blockHeight, res); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Start worker pool
+	for w := 1; w <= p.Options.concurrency(); w++ {
+		blockWorkerWg.Add(1)
+
+		// Worker gets block heights from blockHeightChan, fetches them, and hands them to Emit
+		go func() {
+			defer blockWorkerWg.Done()
+			for blockHeights := range blockHeightChan {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if limiter != nil && !limiter.acquire(ctx) {
+					return
+				}
+
+				var results []interface{}
+				var errs []error
+				start := time.Now()
+				metrics.fetch(blockHeights, func() []error {
+					if len(blockHeights) > 1 {
+						results, errs = p.FetchBatch(ctx, blockHeights)
+					} else {
+						res, err := p.Fetch(ctx, blockHeights[0])
+						results, errs = []interface{}{res}, []error{err}
+					}
+					return errs
+				})
+
+				if limiter != nil {
+					failed := false
+					for _, err := range errs {
+						failed = failed || (err != nil && ctx.Err() == nil)
+					}
+					limiter.release(time.Since(start), failed)
+				}
+
+				for i, blockHeight := range blockHeights {
+					if !deliver(blockHeight, results[i], errs[i]) {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	// Push blockheights into channel, for workers to pick up
+	var headErr error
+producer:
+	for currentBlockNumber := startBlock; currentBlockNumber <= endBlock || heads != nil; {
+		if currentBlockNumber > endBlock {
+			endBlock, headErr = heads.waitForHeight(ctx, currentBlockNumber)
+			if headErr != nil {
+				break producer
+			}
+			metrics.rangeUpdated(startBlock, endBlock)
+		}
+
+		batch := make([]int64, 0, batchSize)
+		for ; len(batch) < batchSize && currentBlockNumber <= endBlock; currentBlockNumber++ {
+			if p.Options.Ordered {
+				select {
+				case window <- struct{}{}:
+				case <-ctx.Done():
+					break producer
+				}
+			}
+
+			if checkpointer != nil && checkpointer.IsDone(currentBlockNumber) {
+				metrics.skipped(currentBlockNumber)
+				if p.Options.Ordered { // the emitter needs to know it can move on
+					select {
+					case resultChan <- fetchResult{height: currentBlockNumber, skipped: true}:
+					case <-ctx.Done():
+						break producer
+					}
+				}
+				continue
+			}
+			batch = append(batch, currentBlockNumber)
+		}
+
+		if len(batch) == 0 {
+			continue
+		}
+
+		select {
+		case blockHeightChan <- batch:
+		case <-ctx.Done():
+			break producer
+		}
+	}
+
+	// Close worker channel and wait for workers (and the emitter) to finish
+	close(blockHeightChan)
+	blockWorkerWg.Wait()
+	if p.Options.Ordered {
+		close(resultChan)
+	}
+	<-emitterDone
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if headErr != nil {
+		return headErr
+	}
+
+	if len(blockErrors) > 0 {
+		sort.Slice(blockErrors, func(i, j int) bool { return blockErrors[i].Height < blockErrors[j].Height })
+		return &BlockRangeError{Errors: blockErrors}
+	}
+	return nil
+}
+
+// emitOrdered buffers the worker results and emits them in ascending height order, starting at nextHeight
+func (p *BlockPipeline) emitOrdered(ctx context.Context, nextHeight int64, resultChan <-chan fetchResult, window <-chan struct{}, metrics *pipelineMetrics, done chan<- struct{}) {
+	defer close(done)
+
+	pending := make(map[int64]fetchResult)
+	for res := range resultChan {
+		pending[res.height] = res
+		if res.err == nil && !res.skipped {
+			metrics.buffered(1)
+		}
+
+		for {
+			next, found := pending[nextHeight]
+			if !found {
+				break
+			}
+
+			delete(pending, nextHeight)
+			nextHeight++
+
+			// Keep draining after cancellation, so that no worker blocks on resultChan
+			if next.err == nil && !next.skipped {
+				metrics.buffered(-1)
+				if ctx.Err() == nil {
+					metrics.emit(ctx, p, next.height, next.result)
+				}
+			}
+			<-window
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// RetryPolicy configures how failed RPC calls are retried. The zero value makes a single attempt (no retries).
+type RetryPolicy struct {
+	MaxAttempts    int           // total number of attempts including the first one. <= 1 means no retries
+	InitialBackoff time.Duration // wait time before the first retry
+	MaxBackoff     time.Duration // upper limit for the wait time between attempts (0 = no limit)
+	Multiplier     float64       // factor by which the wait time grows after each retry (defaults to 2)
+	Jitter         float64       // randomizes each wait time by +/- this fraction (eg. 0.2 = +/- 20%)
+
+	// IsRetryable decides whether an error is worth retrying. Defaults to IsRetryableError.
+	IsRetryable func(err error) bool
+}
+
+// DefaultRetryPolicy is a reasonable policy for hosted providers: 5 attempts within about 4 seconds
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 250 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// RetryError is returned by RetryPolicy.Do when the call could not be completed
+type RetryError struct {
+	Attempts int
+	Err      error // the error of the last attempt
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// Do calls fn until it succeeds, fails with a non-retryable error, the attempts are used up or ctx is done. It returns
+// the number of attempts made. Failures are returned as *RetryError.
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) (attempts int, err error) {
+	backoff := p.InitialBackoff
+	for {
+		attempts++
+		err = fn(ctx)
+		if err == nil {
+			return attempts, nil
+		}
+
+		if attempts >= p.MaxAttempts || ctx.Err() != nil || !p.isRetryable(err) {
+			return attempts, &RetryError{Attempts: attempts, Err: err}
+		}
+
+		timer := time.NewTimer(p.jitter(backoff))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, &RetryError{Attempts: attempts, Err: err}
+		}
+
+		backoff = p.nextBackoff(backoff)
+	}
+}
+
+func (p RetryPolicy) isRetryable(err error) bool {
+	if p.IsRetryable != nil {
+		return p.IsRetryable(err)
+	}
+	return IsRetryableError(err)
+}
+
+func (p RetryPolicy) nextBackoff(backoff time.Duration) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	backoff = time.Duration(float64(backoff) * multiplier)
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+func (p RetryPolicy) jitter(backoff time.Duration) time.Duration {
+	if p.Jitter <= 0 || backoff <= 0 {
+		return backoff
+	}
+	delta := (rand.Float64()*2 - 1) * p.Jitter * float64(backoff)
+	return backoff + time.Duration(delta)
+}
+
+// IsRetryableError returns true for errors which are likely transient: network/connection errors, timeouts,
+// rate limiting and 5xx HTTP responses (see ethrpc.IsTransientError). Context cancellation, ethereum.NotFound and
+// regular JSON-RPC errors (eg. reverted calls, invalid params) are not retryable.
+func IsRetryableError(err error) bool {
+	return ethrpc.IsTransientError(err)
+}
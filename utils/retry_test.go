@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var errUnavailable = rpc.HTTPError{StatusCode: 503, Status: "503 Service Unavailable"}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3}
+	backoff := p.InitialBackoff
+	for _, expected := range []time.Duration{300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second} {
+		backoff = p.nextBackoff(backoff)
+		if backoff != expected {
+			t.Fatalf("got backoff %s, expected %s", backoff, expected)
+		}
+	}
+
+	// Multiplier defaults to 2, without MaxBackoff there's no limit
+	p = RetryPolicy{InitialBackoff: time.Second}
+	if backoff := p.nextBackoff(time.Hour); backoff != 2*time.Hour {
+		t.Errorf("got backoff %s, expected 2h", backoff)
+	}
+}
+
+func TestRetryJitter(t *testing.T) {
+	p := RetryPolicy{Jitter: 0.2}
+	min, max := time.Hour, time.Duration(0)
+	for i := 0; i < 1000; i++ {
+		backoff := p.jitter(time.Second)
+		if backoff < min {
+			min = backoff
+		}
+		if backoff > max {
+			max = backoff
+		}
+	}
+	if min < 800*time.Millisecond || max > 1200*time.Millisecond {
+		t.Errorf("jittered backoff between %s and %s, expected 800ms-1.2s", min, max)
+	}
+	if max-min < 200*time.Millisecond {
+		t.Errorf("jittered backoff between %s and %s, expected it to be spread out", min, max)
+	}
+
+	if backoff := (RetryPolicy{}).jitter(time.Second); backoff != time.Second {
+		t.Errorf("got backoff %s without jitter", backoff)
+	}
+}
+
+// failing returns a function which fails with err the first n times
+func failing(n int, err error) (fn func(ctx context.Context) error, calls *int) {
+	calls = new(int)
+	return func(ctx context.Context) error {
+		*calls++
+		if *calls <= n {
+			return err
+		}
+		return nil
+	}, calls
+}
+
+func TestRetryDo(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 4, InitialBackoff: 10 * time.Millisecond, Multiplier: 2}
+
+	// Succeeds after two retries, waiting 10ms and 20ms
+	fn, _ := failing(2, errUnavailable)
+	start := time.Now()
+	if attempts, err := p.Do(context.Background(), fn); attempts != 3 || err != nil {
+		t.Errorf("got %d attempts and err %v, expected success after 3", attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("retried after %s, expected backoff of 30ms", elapsed)
+	}
+
+	// Gives up after MaxAttempts
+	fn, calls := failing(10, errUnavailable)
+	attempts, err := p.Do(context.Background(), fn)
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 4 || attempts != 4 || *calls != 4 {
+		t.Errorf("got %d attempts and err %v, expected RetryError after 4", attempts, err)
+	}
+	var httpErr rpc.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 503 {
+		t.Errorf("RetryError doesn't wrap the last error: %v", err)
+	}
+
+	// Stops at the first non-retryable error
+	reverted := errors.New("execution reverted")
+	fn, calls = failing(10, reverted)
+	attempts, err = p.Do(context.Background(), fn)
+	if !errors.Is(err, reverted) || attempts != 1 || *calls != 1 {
+		t.Errorf("got %d attempts and err %v, expected to stop after 1", attempts, err)
+	}
+
+	// Custom classifier
+	p.IsRetryable = func(err error) bool { return err == reverted }
+	fn, _ = failing(1, reverted)
+	if attempts, err := p.Do(context.Background(), fn); attempts != 2 || err != nil {
+		t.Errorf("got %d attempts and err %v, expected success after 2", attempts, err)
+	}
+
+	// The zero value makes a single attempt
+	fn, calls = failing(1, errUnavailable)
+	attempts, err = RetryPolicy{}.Do(context.Background(), fn)
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 || attempts != 1 || *calls != 1 {
+		t.Errorf("got %d attempts and err %v, expected a single attempt", attempts, err)
+	}
+}
+
+func TestRetryDoCancel(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// Cancellation during the backoff returns the error of the last attempt right away
+	fn, calls := failing(10, errUnavailable)
+	start := time.Now()
+	attempts, err := p.Do(ctx, fn)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("returned %s after cancellation", elapsed)
+	}
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 || attempts != 1 || *calls != 1 {
+		t.Errorf("got %d attempts and err %v, expected RetryError after 1", attempts, err)
+	}
+	var httpErr rpc.HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Errorf("expected the last error, got %v", err)
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{errUnavailable, true},
+		{rpc.HTTPError{StatusCode: 429}, true},
+		{rpc.HTTPError{StatusCode: 400}, false},
+		{errors.New("read tcp: connection reset by peer"), true},
+		{fmt.Errorf("block 1: %w", context.DeadlineExceeded), false},
+		{context.Canceled, false},
+		{ethereum.NotFound, false},
+		{errors.New("execution reverted"), false},
+	}
+	for _, tt := range tests {
+		if retryable := IsRetryableError(tt.err); retryable != tt.retryable {
+			t.Errorf("%v: got retryable %v, expected %v", tt.err, retryable, tt.retryable)
+		}
+	}
+}
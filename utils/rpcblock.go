@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/go-ethutils/ethrpc"
+)
+
+// rpcBlockBody holds the parts of an eth_getBlockByNumber response which are not in the header
+type rpcBlockBody struct {
+	Hash         common.Hash      `json:"hash"`
+	Transactions []rpcTransaction `json:"transactions"`
+	UncleHashes  []common.Hash    `json:"uncles"`
+}
+
+// rpcTransaction is a transaction with the sender the node returns
+type rpcTransaction struct {
+	tx *types.Transaction
+	txExtraInfo
+}
+
+type txExtraInfo struct {
+	From *common.Address `json:"from,omitempty"`
+}
+
+func (tx *rpcTransaction) UnmarshalJSON(msg []byte) error {
+	if err := json.Unmarshal(msg, &tx.tx); err != nil {
+		return err
+	}
+	return json.Unmarshal(msg, &tx.txExtraInfo)
+}
+
+var errSenderNotCached = errors.New("sender not cached")
+
+// senderFromServer is a types.Signer which returns the sender address the node sent with the block. Like ethclient,
+// it is stored in the sender cache of the transaction (see types.Sender), which GetTxSender reads before recovering
+// the sender from the signature.
+type senderFromServer struct {
+	addr common.Address
+}
+
+func setSenderFromServer(tx *types.Transaction, addr common.Address) {
+	types.Sender(&senderFromServer{addr}, tx) // only for the side effect of caching it
+}
+
+// cachedSender returns the sender address cached by setSenderFromServer
+func cachedSender(tx *types.Transaction) (common.Address, error) {
+	return types.Sender(&senderFromServer{}, tx)
+}
+
+func (s *senderFromServer) Equal(other types.Signer) bool {
+	_, ok := other.(*senderFromServer)
+	return ok
+}
+
+func (s *senderFromServer) Sender(tx *types.Transaction) (common.Address, error) {
+	if s.addr == (common.Address{}) {
+		return common.Address{}, errSenderNotCached
+	}
+	return s.addr, nil
+}
+
+func (s *senderFromServer) ChainID() *big.Int {
+	panic("can't sign with senderFromServer")
+}
+
+func (s *senderFromServer) Hash(tx *types.Transaction) common.Hash {
+	panic("can't sign with senderFromServer")
+}
+
+func (s *senderFromServer) SignatureValues(tx *types.Transaction, sig []byte) (R, S, V *big.Int, err error) {
+	panic("can't sign with senderFromServer")
+}
+
+// GetBlocksBatch downloads several blocks with a single JSON-RPC batch request of eth_getBlockByNumber calls (plus
+// one more batch for uncle headers, if there are any). Returns a block and an error for each height. Heights whose
+// call failed are retried according to the retry policy.
+func GetBlocksBatch(ctx context.Context, caller ethrpc.RPCCaller, heights []int64, retry RetryPolicy) (blocks []*types.Block, errs []error) {
+	blocks = make([]*types.Block, len(heights))
+	errs = make([]error, len(heights))
+
+	// Indices of heights that still need to be fetched
+	pending := make([]int, len(heights))
+	for i := range heights {
+		pending[i] = i
+	}
+
+	attempts, err := retry.Do(ctx, func(ctx context.Context) error {
+		batch := make([]rpc.BatchElem, len(pending))
+		rawBlocks := make([]json.RawMessage, len(pending))
+		for i, idx := range pending {
+			batch[i] = rpc.BatchElem{Method: "eth_getBlockByNumber", Args: []interface{}{hexutil.EncodeUint64(uint64(heights[idx])), true}, Result: &rawBlocks[i]}
+		}
+
+		if err := caller.BatchCallContext(ctx, batch); err != nil {
+			for _, idx := range pending {
+				errs[idx] = err
+			}
+			return err
+		}
+
+		// Only the failed heights are requested again. Return a retryable error if there is one, so that they are retried.
+		var failed []int
+		var batchErr error
+		for i, idx := range pending {
+			err := batch[i].Error
+			if err == nil {
+				blocks[idx], err = decodeRPCBlock(ctx, caller, rawBlocks[i])
+			}
+
+			errs[idx] = err
+			if err != nil {
+				failed = append(failed, idx)
+				if batchErr == nil || (!retry.isRetryable(batchErr) && retry.isRetryable(err)) {
+					batchErr = err
+				}
+			}
+		}
+
+		pending = failed
+		return batchErr
+	})
+
+	if err != nil {
+		for _, idx := range pending {
+			errs[idx] = &RetryError{Attempts: attempts, Err: errs[idx]}
+		}
+	}
+	return blocks, errs
+}
+
+// decodeRPCBlock decodes a JSON block with full transactions into a *types.Block, the same way ethclient does (including
+// caching the senders the node returns)
+func decodeRPCBlock(ctx context.Context, caller ethrpc.RPCCaller, raw json.RawMessage) (*types.Block, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ethereum.NotFound
+	}
+
+	var head *types.Header
+	var body rpcBlockBody
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, err
+	}
+
+	// Quick-verify transaction and uncle lists
+	if (head.UncleHash == types.EmptyUncleHash) != (len(body.UncleHashes) == 0) {
+		return nil, fmt.Errorf("uncle list of block %s doesn't match the header", body.Hash)
+	}
+	if (head.TxHash == types.EmptyRootHash) != (len(body.Transactions) == 0) {
+		return nil, fmt.Errorf("transaction list of block %s doesn't match the header", body.Hash)
+	}
+
+	// Load uncles because they are not included in the block response
+	var uncles []*types.Header
+	if len(body.UncleHashes) > 0 {
+		uncles = make([]*types.Header, len(body.UncleHashes))
+		batch := make([]rpc.BatchElem, len(body.UncleHashes))
+		for i := range batch {
+			batch[i] = rpc.BatchElem{Method: "eth_getUncleByBlockHashAndIndex", Args: []interface{}{body.Hash, hexutil.EncodeUint64(uint64(i))}, Result: &uncles[i]}
+		}
+		if err := caller.BatchCallContext(ctx, batch); err != nil {
+			return nil, err
+		}
+		for i := range batch {
+			if batch[i].Error != nil {
+				return nil, batch[i].Error
+			}
+			if uncles[i] == nil {
+				return nil, fmt.Errorf("got null header for uncle %d of block %s", i, body.Hash)
+			}
+		}
+	}
+
+	txs := make([]*types.Transaction, len(body.Transactions))
+	for i, tx := range body.Transactions {
+		if tx.From != nil {
+			setSenderFromServer(tx.tx, *tx.From)
+		}
+		txs[i] = tx.tx
+	}
+	return types.NewBlockWithHeader(head).WithBody(txs, uncles), nil
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/metachris/go-ethutils/rpctest"
+)
+
+func TestGetBlocksBatchSenders(t *testing.T) {
+	fixtures, err := rpctest.LoadFixtures("../rpctest/testdata/chain.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpctest.NewServer(fixtures)
+	defer server.Close()
+	client, err := rpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	blocks, errs := GetBlocksBatch(context.Background(), client, []int64{12323931, 12323935, 12323939}, RetryPolicy{})
+	numTxs := 0
+	for i, block := range blocks {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+
+		// The senders of the node are cached, and GetTxSender doesn't need to recover them
+		for _, tx := range block.Transactions() {
+			numTxs++
+			cached, err := cachedSender(tx)
+			if err != nil {
+				t.Fatalf("tx %s: %v", tx.Hash(), err)
+			}
+			sender, err := GetTxSender(tx)
+			if err != nil || sender != cached {
+				t.Errorf("tx %s: GetTxSender returned %s (err %v), expected %s", tx.Hash(), sender, err, cached)
+			}
+
+			// and it is the signer of the transaction
+			if recovered, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx); err != nil || recovered != cached {
+				t.Errorf("tx %s: cached sender %s, but recovered %s (err %v)", tx.Hash(), cached, recovered, err)
+			}
+		}
+	}
+	if numTxs != 9 {
+		t.Errorf("got %d transactions, expected 9", numTxs)
+	}
+
+	// Without a sender from the node, it is recovered
+	data, err := blocks[0].Transactions()[0].MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cachedSender(tx); err != errSenderNotCached {
+		t.Errorf("expected errSenderNotCached, got %v", err)
+	}
+	recovered, _ := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if sender, err := GetTxSender(tx); err != nil || sender != recovered {
+		t.Errorf("GetTxSender returned %s (err %v), expected %s", sender, err, recovered)
+	}
+}